@@ -0,0 +1,44 @@
+// Package client provides http.RoundTripper middleware for outbound requests that mirrors the
+// server side middleware: logging, Prometheus metrics, request-id and trace propagation and retries.
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Middleware wraps a RoundTripper, the same way a server middleware wraps an http.Handler
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to allow the use of ordinary functions as RoundTrippers
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// Chain wraps base with the middlewares, the first one is the outermost. If base is nil
+// http.DefaultTransport is used.
+//
+//	transport := client.Chain(nil, client.Logging(logger), client.Metrics(hist), client.Retry(client.RetryCfg{}))
+func Chain(base http.RoundTripper, mws ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		base = mws[i](base)
+	}
+	return base
+}
+
+type routeKey struct{}
+
+// WithRoute sets the route template of the outbound request, e.g. "/users/{id}", it is used as
+// metric label instead of the path to keep the cardinality low.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route template set with WithRoute
+func RouteFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
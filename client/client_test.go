@@ -0,0 +1,142 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newMemSlog returns a logger writing text without time and req-dur, so the output can be compared
+func newMemSlog(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "req-dur" || a.Key == slog.MessageKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func TestLogging(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	tcs := []struct {
+		name   string
+		path   string
+		expect string
+	}{
+		{name: "success", path: "/ok", expect: "level=INFO method=GET url=" + srv.URL + "/ok response-code=200 req-id=abc\n"},
+		{name: "server error", path: "/fail", expect: "level=ERROR method=GET url=" + srv.URL + "/fail response-code=500 req-id=abc\n"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := &http.Client{Transport: client.Chain(nil, client.Logging(newMemSlog(&buf)))}
+			req, _ := http.NewRequest("GET", srv.URL+tc.path, nil)
+			req.Header.Set(client.HeaderRequestID, "abc")
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if buf.String() != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, buf.String())
+			}
+		})
+	}
+}
+
+func TestLogging_TransportError(t *testing.T) {
+	var buf bytes.Buffer
+	failing := client.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	c := &http.Client{Transport: client.Chain(failing, client.Logging(newMemSlog(&buf)))}
+	_, err := c.Get("http://upstream.local/a")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	expect := "level=ERROR method=GET url=http://upstream.local/a req-id=\"\" err=\"connection refused\"\n"
+	if buf.String() != expect {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	hist, err := client.NewPromHistogram("test", nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: client.Chain(nil, client.Metrics(hist))}
+	req, _ := http.NewRequestWithContext(client.WithRoute(context.Background(), "/users/{id}"), "GET", srv.URL+"/users/1", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "test_http_client_duration_seconds" {
+		t.Fatalf("unexpected metrics %v", families)
+	}
+	labels := map[string]string{}
+	for _, l := range families[0].GetMetric()[0].GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["route"] != "/users/{id}" || labels["status"] != "200" || labels["host"] != strings.TrimPrefix(srv.URL, "http://") {
+		t.Errorf("unexpected labels %v", labels)
+	}
+}
+
+func TestPropagate(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	c := &http.Client{Transport: client.Chain(nil, client.Propagate)}
+	h := client.Inbound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", upstream.URL, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Request-Id", "abc")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Get("Request-Id") != "abc" || got.Get("Tracestate") != "vendor=1" {
+		t.Errorf("expected headers to be propagated, got %v", got)
+	}
+	tp := strings.Split(got.Get("Traceparent"), "-")
+	if len(tp) != 4 || tp[1] != "4bf92f3577b34da6a3ce929d0e0e4736" || tp[2] == "00f067aa0ba902b7" || tp[3] != "01" {
+		t.Errorf("expected child traceparent of the same trace, got %q", got.Get("Traceparent"))
+	}
+}
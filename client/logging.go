@@ -0,0 +1,46 @@
+package client
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// Logging returns a middleware that logs outbound requests with the same attribute names as
+// middleware.Logging. Server errors and transport errors are logged at ERROR, everything else at INFO.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if logger == nil {
+			return next
+		}
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			timeStart := time.Now()
+			resp, err := next.RoundTrip(req)
+			dur := time.Since(timeStart)
+
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("url", req.URL.Redacted()),
+				slog.Duration("req-dur", dur),
+			}
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode
+				attrs = append(attrs, slog.Int("response-code", statusCode))
+			}
+			attrs = append(attrs, slog.String("req-id", requestID(req)))
+
+			level := slog.LevelInfo
+			if err != nil {
+				attrs = append(attrs, slog.String("err", err.Error()))
+				level = slog.LevelError
+			} else if middleware.IsServerErr(statusCode) {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(req.Context(), level, "", attrs...)
+			return resp, err
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Histogram ensures that the outbound metric has been initialized correctly with NewPromHistogram
+type Histogram struct {
+	h *prometheus.HistogramVec
+}
+
+// NewPromHistogram registers a histogram of outbound request durations labelled by host, route
+// template (see WithRoute), method, status code and error flag. Transport errors have status 0.
+func NewPromHistogram(prefix string, buckets []float64, registry prometheus.Registerer) (Histogram, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	if prefix == "" {
+		prefix = "requests"
+	}
+
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prefix,
+		Subsystem: "http_client",
+		Name:      "duration_seconds",
+		Help:      "Duration of outbound HTTP requests for different hosts, routes, methods, status codes",
+		Buckets:   buckets,
+	},
+		[]string{
+			"host",
+			"route",
+			"method",
+			"status",
+			"isError",
+		},
+	)
+	if err := registry.Register(histogram); err != nil {
+		return Histogram{}, fmt.Errorf("registering prometheus histogram: %w", err)
+	}
+	return Histogram{h: histogram}, nil
+}
+
+// Metrics returns a middleware that records the duration of outbound requests
+func Metrics(hist Histogram) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if hist.h == nil {
+			return next
+		}
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			timeStart := time.Now()
+			resp, err := next.RoundTrip(req)
+			dur := time.Since(timeStart)
+
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode
+			}
+			hist.h.With(prometheus.Labels{
+				"host":    req.URL.Host,
+				"route":   RouteFromContext(req.Context()),
+				"method":  req.Method,
+				"status":  strconv.Itoa(statusCode),
+				"isError": strconv.FormatBool(err != nil || middleware.IsStatusError(statusCode)),
+			}).Observe(dur.Seconds())
+			return resp, err
+		})
+	}
+}
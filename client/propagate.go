@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// HeaderRequestID is the request id header read by middleware.Logging, see middleware.HeaderRequestID
+const HeaderRequestID = middleware.HeaderRequestID
+
+// propagatedHeaders are copied from the inbound to the outbound requests, following the W3C trace context
+var propagatedHeaders = []string{HeaderRequestID, "Traceparent", "Tracestate", "Baggage"}
+
+type propagationKey struct{}
+
+// Inbound is a server middleware that keeps the request id and W3C trace context headers of the
+// inbound request in its context, so Propagate can forward them on outbound requests made with it.
+func Inbound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithPropagation(r.Context(), r.Header)))
+	})
+}
+
+// WithPropagation stores the headers to propagate from h in ctx, e.g. in a message consumer
+func WithPropagation(ctx context.Context, h http.Header) context.Context {
+	p := http.Header{}
+	for _, name := range propagatedHeaders {
+		if v := h.Get(name); v != "" {
+			p.Set(name, v)
+		}
+	}
+	if len(p) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, propagationKey{}, p)
+}
+
+func propagationFrom(ctx context.Context) http.Header {
+	h, _ := ctx.Value(propagationKey{}).(http.Header)
+	return h
+}
+
+// Propagate is a middleware that sets the request id and trace context headers stored in the
+// request context on outbound requests, headers already set on the request are kept.
+// The traceparent gets a new parent id, so the outbound call is a child of the inbound one.
+func Propagate(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		p := propagationFrom(req.Context())
+		if len(p) == 0 {
+			return next.RoundTrip(req)
+		}
+		// a RoundTripper must not modify the request
+		req = req.Clone(req.Context())
+		for name, v := range p {
+			if req.Header.Get(name) != "" {
+				continue
+			}
+			if name == "Traceparent" {
+				v = []string{childTraceparent(v[0])}
+			}
+			req.Header[name] = v
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+// childTraceparent replaces the parent id of a version-traceid-parentid-flags traceparent
+func childTraceparent(tp string) string {
+	parts := strings.Split(tp, "-")
+	if len(parts) < 4 || len(parts[2]) != 16 {
+		return tp
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return tp
+	}
+	parts[2] = hex.EncodeToString(b)
+	return strings.Join(parts, "-")
+}
+
+// requestID returns the request id of an outbound request, before or after propagation
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(HeaderRequestID); id != "" {
+		return id
+	}
+	return propagationFrom(req.Context()).Get(HeaderRequestID)
+}
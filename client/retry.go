@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryCfg configures the retry middleware
+type RetryCfg struct {
+	// MaxAttempts is the total number of attempts including the first one, default 3
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles on every attempt, default 100ms
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay and the honoured Retry-After, default 10s.
+	// A Retry-After longer than MaxDelay is not waited for, the response is returned instead.
+	MaxDelay time.Duration
+	// Methods that are retried, default the idempotent methods GET, HEAD, OPTIONS, TRACE, PUT and DELETE.
+	// Requests carrying an Idempotency-Key header are retried regardless of the method.
+	Methods []string
+	// ShouldRetry decides if an attempt is retried, by default transport errors and the status codes
+	// 429, 502, 503 and 504 are retried. Canceled contexts are never retried.
+	ShouldRetry func(resp *http.Response, err error) bool
+}
+
+// Retry returns a middleware that retries failed requests with exponential backoff and equal
+// jitter, i.e. a random delay between half and the full backoff, honouring the Retry-After
+// response header. Requests with a body are only retried if the body can be obtained again with
+// GetBody, which http.NewRequest sets for in memory bodies.
+func Retry(cfg RetryCfg) Middleware {
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.BaseDelay == 0 {
+		cfg.BaseDelay = 100 * time.Millisecond
+	}
+	if cfg.MaxDelay == 0 {
+		cfg.MaxDelay = 10 * time.Second
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+	}
+	if cfg.ShouldRetry == nil {
+		cfg.ShouldRetry = defaultShouldRetry
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !cfg.retryable(req) {
+				return next.RoundTrip(req)
+			}
+			for attempt := 1; ; attempt++ {
+				resp, err := next.RoundTrip(req)
+				if attempt >= cfg.MaxAttempts || req.Context().Err() != nil || !cfg.ShouldRetry(resp, err) {
+					return resp, err
+				}
+				delay := cfg.backoff(attempt)
+				if resp != nil {
+					if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+						if d > cfg.MaxDelay {
+							return resp, err
+						}
+						delay = d
+					}
+					drain(resp.Body)
+				}
+
+				if err := sleep(req.Context(), delay); err != nil {
+					return nil, err
+				}
+				if req.Body != nil && req.Body != http.NoBody {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req = req.Clone(req.Context())
+					req.Body = body
+				}
+			}
+		})
+	}
+}
+
+func (cfg RetryCfg) retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	return slices.Contains(cfg.Methods, req.Method) || req.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff returns a random delay between half and the full exponential delay of the attempt
+func (cfg RetryCfg) backoff(attempt int) time.Duration {
+	d := cfg.BaseDelay << min(attempt-1, 30)
+	if d <= 0 || d > cfg.MaxDelay {
+		d = cfg.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1) //nolint:gosec // jitter does not need a secure random source
+}
+
+func defaultShouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After value in seconds or as HTTP date
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// drain reads a bit of the discarded body so the connection can be reused
+func drain(body io.ReadCloser) {
+	_, _ = io.CopyN(io.Discard, body, 4096)
+	_ = body.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/client"
+)
+
+func TestRetry(t *testing.T) {
+	tcs := []struct {
+		name       string
+		method     string
+		idemKey    bool
+		statuses   []int
+		expectCode int
+		expectCall int32
+	}{
+		{name: "success first", method: "GET", statuses: []int{200}, expectCode: 200, expectCall: 1},
+		{name: "retry until success", method: "GET", statuses: []int{503, 502, 200}, expectCode: 200, expectCall: 3},
+		{name: "max attempts", method: "GET", statuses: []int{503, 503, 503, 200}, expectCode: 503, expectCall: 3},
+		{name: "not retryable status", method: "GET", statuses: []int{500, 200}, expectCode: 500, expectCall: 1},
+		{name: "post not retried", method: "POST", statuses: []int{503, 200}, expectCode: 503, expectCall: 1},
+		{name: "post with idempotency key", method: "POST", idemKey: true, statuses: []int{503, 200}, expectCode: 200, expectCall: 2},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				if body, _ := io.ReadAll(r.Body); r.Method == "POST" && string(body) != "payload" {
+					t.Errorf("expected body to be resent, got %q", body)
+				}
+				w.WriteHeader(tc.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			c := &http.Client{Transport: client.Chain(nil, client.Retry(client.RetryCfg{BaseDelay: time.Millisecond}))}
+			req, _ := http.NewRequest(tc.method, srv.URL, strings.NewReader("payload"))
+			if tc.idemKey {
+				req.Header.Set("Idempotency-Key", "k1")
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tc.expectCode || calls.Load() != tc.expectCall {
+				t.Errorf("expected %d after %d calls, got %d after %d", tc.expectCode, tc.expectCall, resp.StatusCode, calls.Load())
+			}
+		})
+	}
+}
+
+func TestRetry_RetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", r.URL.Query().Get("after"))
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: client.Chain(nil, client.Retry(client.RetryCfg{BaseDelay: time.Millisecond, MaxDelay: 2 * time.Second}))}
+
+	start := time.Now()
+	resp, err := c.Get(srv.URL + "?after=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != 200 || time.Since(start) < time.Second {
+		t.Errorf("expected retry after 1s, got %d after %s", resp.StatusCode, time.Since(start))
+	}
+
+	// a Retry-After beyond MaxDelay returns the response
+	calls.Store(0)
+	resp, err = c.Get(srv.URL + "?after=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Errorf("expected 429 without retry, got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
@@ -0,0 +1,149 @@
+// Package admin bundles the operational endpoints of a service: pprof, prometheus metrics,
+// build information and the runtime log level, meant to be served on a separate admin port.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Cfg configures the admin handler
+type Cfg struct {
+	// Auth guards all endpoints, e.g. auth.Middleware(...) of middleware/auth. It is required
+	// unless AllowUnauthenticated is set.
+	Auth                 func(http.Handler) http.Handler
+	AllowUnauthenticated bool
+
+	// Gatherer is served on /metrics, use the registry passed to NewPromHistogram;
+	// default prometheus.DefaultGatherer
+	Gatherer prometheus.Gatherer
+	// Pprof enables the net/http/pprof endpoints under /debug/pprof/
+	Pprof bool
+	// LevelVar is the level of the slog handler used by Logging, it enables /loglevel; nil disables it
+	LevelVar *slog.LevelVar
+	Logger   *slog.Logger
+}
+
+// New returns the admin handler serving:
+//   - GET /metrics: the prometheus metrics
+//   - GET /version: the build information of the binary
+//   - GET /loglevel and PUT /loglevel: read and change the log level, e.g. {"level":"DEBUG"}
+//   - /debug/pprof/: the pprof endpoints, when enabled
+func New(cfg Cfg) (http.Handler, error) {
+	if cfg.Auth == nil && !cfg.AllowUnauthenticated {
+		return nil, errors.New("admin endpoints need an auth middleware, or AllowUnauthenticated set")
+	}
+	if cfg.Gatherer == nil {
+		cfg.Gatherer = prometheus.DefaultGatherer
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(cfg.Gatherer, promhttp.HandlerOpts{}))
+	mux.HandleFunc("GET /version", versionHandler)
+	if cfg.LevelVar != nil {
+		lh := levelHandler{level: cfg.LevelVar, logger: cfg.Logger}
+		mux.HandleFunc("GET /loglevel", lh.get)
+		mux.HandleFunc("PUT /loglevel", lh.set)
+	}
+	if cfg.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	if cfg.Auth == nil {
+		return mux, nil
+	}
+	return cfg.Auth(mux), nil
+}
+
+// Version is the response of /version
+type Version struct {
+	GoVersion string `json:"goVersion"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// BuildVersion reads the version from the build information embedded by the go toolchain
+func BuildVersion() Version {
+	v := Version{GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Path = info.Main.Path
+	v.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.Time = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	return v
+}
+
+func versionHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, BuildVersion())
+}
+
+type levelHandler struct {
+	level  *slog.LevelVar
+	logger *slog.Logger
+}
+
+type levelPayload struct {
+	Level string `json:"level"`
+}
+
+func (l levelHandler) get(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, levelPayload{Level: l.level.Level().String()})
+}
+
+// set accepts {"level":"DEBUG"} or ?level=debug, levels like "INFO+2" are supported
+func (l levelHandler) set(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("level")
+	if name == "" {
+		var p levelPayload
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&p); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		name = p.Level
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		http.Error(w, fmt.Sprintf("invalid level %q", name), http.StatusBadRequest)
+		return
+	}
+	previous := l.level.Level()
+	l.level.Set(level)
+	if l.logger != nil {
+		l.logger.Warn("log level changed", slog.String("from", previous.String()), slog.String("to", level.String()))
+	}
+	writeJSON(w, levelPayload{Level: level.String()})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,104 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/handlers/admin"
+	"github.com/go-bumbu/http/middleware"
+	"github.com/go-bumbu/http/middleware/auth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func serve(h http.Handler, method, target, body string, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNew_RequiresAuth(t *testing.T) {
+	if _, err := admin.New(admin.Cfg{}); err == nil {
+		t.Error("expected error without auth")
+	}
+	if _, err := admin.New(admin.Cfg{AllowUnauthenticated: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAdmin_Endpoints(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hist, err := middleware.NewPromHistogram("", nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware.Metrics(hist)(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app", nil))
+
+	level := &slog.LevelVar{}
+	h, err := admin.New(admin.Cfg{
+		Auth:     auth.Middleware(auth.Basic{Verifier: auth.StaticUsers{"ops": "secret"}}),
+		Gatherer: reg,
+		Pprof:    true,
+		LevelVar: level,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		user         string
+		expectCode   int
+		expectInBody string
+	}{
+		{name: "unauthenticated", method: "GET", target: "/metrics", expectCode: http.StatusUnauthorized},
+		{name: "metrics", method: "GET", target: "/metrics", user: "ops", expectCode: http.StatusOK,
+			expectInBody: `requests_http_duration_seconds_count{addr="/app"`},
+		{name: "version", method: "GET", target: "/version", user: "ops", expectCode: http.StatusOK, expectInBody: `"goVersion":"go`},
+		{name: "pprof", method: "GET", target: "/debug/pprof/", user: "ops", expectCode: http.StatusOK, expectInBody: "goroutine"},
+		{name: "get level", method: "GET", target: "/loglevel", user: "ops", expectCode: http.StatusOK, expectInBody: `{"level":"INFO"}`},
+		{name: "invalid level", method: "PUT", target: "/loglevel", body: `{"level":"verbose"}`, user: "ops", expectCode: http.StatusBadRequest},
+		{name: "set level", method: "PUT", target: "/loglevel", body: `{"level":"debug"}`, user: "ops", expectCode: http.StatusOK, expectInBody: `{"level":"DEBUG"}`},
+		{name: "set level query", method: "PUT", target: "/loglevel?level=WARN%2B2", user: "ops", expectCode: http.StatusOK, expectInBody: `{"level":"WARN+2"}`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(h, tc.method, tc.target, tc.body, tc.user)
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected %d, got %d: %s", tc.expectCode, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tc.expectInBody) {
+				t.Errorf("expected %q in body %q", tc.expectInBody, rec.Body.String())
+			}
+		})
+	}
+	if level.Level() != slog.LevelWarn+2 {
+		t.Errorf("expected level to be changed, got %s", level.Level())
+	}
+}
+
+func TestAdmin_Disabled(t *testing.T) {
+	h, err := admin.New(admin.Cfg{AllowUnauthenticated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/debug/pprof/", "/loglevel"} {
+		if rec := serve(h, "GET", target, "", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected %s to be disabled, got %d", target, rec.Code)
+		}
+	}
+	var v admin.Version
+	if err := json.Unmarshal(serve(h, "GET", "/version", "", "").Body.Bytes(), &v); err != nil || v.GoVersion == "" {
+		t.Errorf("unexpected version %+v: %v", v, err)
+	}
+}
@@ -0,0 +1,281 @@
+// Package health provides Kubernetes style liveness and readiness probe handlers backed by
+// named checks.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check is a named health check
+type Check struct {
+	Name string
+	// Check returns an error if the dependency is unhealthy, it should respect ctx
+	Check func(ctx context.Context) error
+	// Timeout bounds a single run, default 5s
+	Timeout time.Duration
+	// CacheTTL reuses the last result for this long, 0 runs the check on every probe
+	CacheTTL time.Duration
+	// Critical checks fail the probe, the others are only reported
+	Critical bool
+	// Liveness includes the check in /livez, by default checks are only part of /readyz.
+	// Liveness checks should only fail when a restart helps, e.g. a deadlock.
+	Liveness bool
+}
+
+// Cfg configures the Handler
+type Cfg struct {
+	Checks  []Check
+	Metrics Metrics
+	Logger  *slog.Logger
+}
+
+// Handler serves the liveness and readiness probes, it is ready from the start; use SetReady
+// (e.g. through server.Cfg.Readiness) to fail readiness during the graceful shutdown.
+type Handler struct {
+	metrics Metrics
+	logger  *slog.Logger
+	ready   atomic.Bool
+
+	mu     sync.RWMutex
+	checks []*check
+}
+
+type check struct {
+	Check
+	mu       sync.Mutex
+	last     result
+	ranAt    time.Time
+	hasRun   bool
+	inflight *flight
+}
+
+// flight is a run shared by the probes that arrive while it is in progress
+type flight struct {
+	done   chan struct{}
+	result result
+}
+
+type result struct {
+	err      error
+	duration time.Duration
+	cached   bool
+}
+
+func New(cfg Cfg) (*Handler, error) {
+	h := &Handler{metrics: cfg.Metrics, logger: cfg.Logger}
+	h.ready.Store(true)
+	for _, c := range cfg.Checks {
+		if err := h.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Register adds a check, names need to be unique
+func (h *Handler) Register(c Check) error {
+	if c.Name == "" || c.Check == nil {
+		return errors.New("health check needs a name and a check function")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, existing := range h.checks {
+		if existing.Name == c.Name {
+			return fmt.Errorf("duplicate health check %q", c.Name)
+		}
+	}
+	h.checks = append(h.checks, &check{Check: c})
+	return nil
+}
+
+// SetReady sets the readiness toggle, /readyz fails while it is false regardless of the checks
+func (h *Handler) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Ready returns the state of the readiness toggle
+func (h *Handler) Ready() bool {
+	return h.ready.Load()
+}
+
+// Livez returns the liveness probe handler, it runs the checks with Liveness set
+func (h *Handler) Livez() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, true, true)
+	})
+}
+
+// Readyz returns the readiness probe handler, it runs all checks
+func (h *Handler) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, false, h.ready.Load())
+	})
+}
+
+// Response is the verbose JSON output of the probes, requested with the "verbose" query parameter
+type Response struct {
+	Status string        `json:"status"`
+	Ready  *bool         `json:"ready,omitempty"`
+	Checks []CheckStatus `json:"checks"`
+}
+
+type CheckStatus struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+	Cached   bool   `json:"cached"`
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, liveness bool, ready bool) {
+	h.mu.RLock()
+	var checks []*check
+	for _, c := range h.checks {
+		if !liveness || c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = h.run(r.Context(), c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	resp := Response{Status: statusOK, Checks: make([]CheckStatus, len(checks))}
+	if !liveness {
+		resp.Ready = &ready
+	}
+	if !ready {
+		resp.Status = statusFail
+	}
+	for i, c := range checks {
+		cs := CheckStatus{Name: c.Name, Status: statusOK, Critical: c.Critical,
+			Duration: results[i].duration.String(), Cached: results[i].cached}
+		if err := results[i].err; err != nil {
+			cs.Status = statusFail
+			cs.Error = err.Error()
+			if c.Critical {
+				resp.Status = statusFail
+			}
+		}
+		resp.Checks[i] = cs
+	}
+
+	code := http.StatusOK
+	if resp.Status != statusOK {
+		code = http.StatusServiceUnavailable
+	}
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if code != http.StatusOK {
+		msg := "unhealthy"
+		if !ready {
+			msg = "not ready"
+		}
+		http.Error(w, msg, code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(statusOK))
+}
+
+// run returns the cached result or waits for the check to finish, concurrent probes share a
+// single in-flight run; a probe that goes away stops waiting but does not cancel the run
+func (h *Handler) run(ctx context.Context, c *check) result {
+	c.mu.Lock()
+	if c.hasRun && c.CacheTTL > 0 && time.Since(c.ranAt) < c.CacheTTL {
+		r := c.last
+		r.cached = true
+		c.mu.Unlock()
+		return r
+	}
+	f := c.inflight
+	if f == nil {
+		f = &flight{done: make(chan struct{})}
+		c.inflight = f
+		// the run is detached from the probe that started it, so it is neither cut short nor
+		// recorded as failing when that probe is canceled
+		go h.execute(context.WithoutCancel(ctx), c, f)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result
+	case <-ctx.Done():
+		return result{err: fmt.Errorf("probe canceled: %w", ctx.Err())}
+	}
+}
+
+// execute runs the check once and records the result
+func (h *Handler) execute(ctx context.Context, c *check, f *flight) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := runCheck(ctx, c.Check.Check)
+	r := result{err: err, duration: time.Since(start)}
+
+	c.mu.Lock()
+	if h.logger != nil && err != nil && (!c.hasRun || c.last.err == nil) {
+		h.logger.Warn("health check failing", slog.String("name", c.Name), slog.Any("err", err))
+	} else if h.logger != nil && err == nil && c.hasRun && c.last.err != nil {
+		h.logger.Info("health check recovered", slog.String("name", c.Name))
+	}
+	c.last, c.ranAt, c.hasRun = r, time.Now(), true
+	c.inflight = nil
+	h.metrics.observe(c.Name, r)
+	c.mu.Unlock()
+
+	f.result = r
+	close(f.done)
+}
+
+// runCheck returns once the check returns or ctx is done, a check ignoring ctx keeps running
+// in the background
+func runCheck(ctx context.Context, fn func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- fmt.Errorf("check panicked: %v", rec)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+}
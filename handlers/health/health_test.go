@@ -0,0 +1,268 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/handlers/health"
+	"github.com/go-bumbu/http/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var _ server.Readiness = (*health.Handler)(nil)
+
+func ok(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("connection refused") }
+
+func probe(t *testing.T, h http.Handler, verbose bool) (int, health.Response, string) {
+	t.Helper()
+	target := "/"
+	if verbose {
+		target = "/?verbose"
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	var resp health.Response
+	if verbose {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("expected json, got %q", rec.Body.String())
+		}
+	}
+	return rec.Code, resp, rec.Body.String()
+}
+
+func TestHandler_Probes(t *testing.T) {
+	tcs := []struct {
+		name        string
+		checks      []health.Check
+		notReady    bool
+		expectLive  int
+		expectReady int
+		expectBody  string
+	}{
+		{
+			name:        "healthy",
+			checks:      []health.Check{{Name: "db", Check: ok, Critical: true}},
+			expectLive:  http.StatusOK,
+			expectReady: http.StatusOK,
+			expectBody:  "ok",
+		},
+		{
+			name:        "critical failure",
+			checks:      []health.Check{{Name: "db", Check: failing, Critical: true}},
+			expectLive:  http.StatusOK,
+			expectReady: http.StatusServiceUnavailable,
+			expectBody:  "unhealthy\n",
+		},
+		{
+			name:        "non critical failure",
+			checks:      []health.Check{{Name: "cache", Check: failing}},
+			expectLive:  http.StatusOK,
+			expectReady: http.StatusOK,
+			expectBody:  "ok",
+		},
+		{
+			name:        "liveness check",
+			checks:      []health.Check{{Name: "deadlock", Check: failing, Critical: true, Liveness: true}},
+			expectLive:  http.StatusServiceUnavailable,
+			expectReady: http.StatusServiceUnavailable,
+			expectBody:  "unhealthy\n",
+		},
+		{
+			name:        "not ready",
+			checks:      []health.Check{{Name: "db", Check: ok, Critical: true}},
+			notReady:    true,
+			expectLive:  http.StatusOK,
+			expectReady: http.StatusServiceUnavailable,
+			expectBody:  "not ready\n",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := health.New(health.Cfg{Checks: tc.checks})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.SetReady(!tc.notReady)
+			if code, _, _ := probe(t, h.Livez(), false); code != tc.expectLive {
+				t.Errorf("expected livez %d, got %d", tc.expectLive, code)
+			}
+			code, _, body := probe(t, h.Readyz(), false)
+			if code != tc.expectReady || body != tc.expectBody {
+				t.Errorf("expected readyz %d %q, got %d %q", tc.expectReady, tc.expectBody, code, body)
+			}
+		})
+	}
+}
+
+func TestHandler_Verbose(t *testing.T) {
+	h, err := health.New(health.Cfg{Checks: []health.Check{
+		{Name: "db", Check: ok, Critical: true},
+		{Name: "cache", Check: failing},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, resp, _ := probe(t, h.Readyz(), true)
+	if code != http.StatusOK || resp.Status != "ok" || resp.Ready == nil || !*resp.Ready {
+		t.Errorf("unexpected response %d %+v", code, resp)
+	}
+	if len(resp.Checks) != 2 || resp.Checks[1].Name != "cache" || resp.Checks[1].Status != "fail" ||
+		resp.Checks[1].Error != "connection refused" || resp.Checks[1].Critical {
+		t.Errorf("unexpected checks %+v", resp.Checks)
+	}
+}
+
+func TestHandler_TimeoutAndCache(t *testing.T) {
+	var runs atomic.Int32
+	h, err := health.New(health.Cfg{Checks: []health.Check{
+		{Name: "slow", Critical: true, Timeout: 10 * time.Millisecond, Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{Name: "cached", CacheTTL: time.Minute, Check: func(context.Context) error {
+			runs.Add(1)
+			return nil
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, resp, _ := probe(t, h.Readyz(), true)
+	if code != http.StatusServiceUnavailable || !strings.Contains(resp.Checks[0].Error, "deadline exceeded") {
+		t.Errorf("expected timeout, got %d %+v", code, resp)
+	}
+	_, resp, _ = probe(t, h.Readyz(), true)
+	if runs.Load() != 1 || !resp.Checks[1].Cached {
+		t.Errorf("expected cached result, got %d runs %+v", runs.Load(), resp.Checks[1])
+	}
+}
+
+func TestHandler_Register(t *testing.T) {
+	h, err := health.New(health.Cfg{Checks: []health.Check{{Name: "db", Check: ok}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Register(health.Check{Name: "db", Check: ok}); err == nil {
+		t.Error("expected duplicate name error")
+	}
+	if err := h.Register(health.Check{Name: "nocheck"}); err == nil {
+		t.Error("expected missing check error")
+	}
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := health.NewPromMetrics("", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := health.New(health.Cfg{Metrics: metrics, Checks: []health.Check{
+		{Name: "db", Check: ok},
+		{Name: "cache", Check: failing},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe(t, h.Readyz(), false)
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`requests_health_check_status{name="cache"} 0`,
+		`requests_health_check_status{name="db"} 1`,
+		`requests_health_check_duration_seconds{name="db"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("expected metric line %s", line)
+		}
+	}
+}
+
+func TestHandler_SingleFlight(t *testing.T) {
+	var runs atomic.Int32
+	release := make(chan struct{})
+	h, err := health.New(health.Cfg{Checks: []health.Check{
+		{Name: "db", Critical: true, Check: func(ctx context.Context) error {
+			runs.Add(1)
+			<-release
+			return nil
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			code, _, _ := probe(t, h.Readyz(), false)
+			codes <- code
+		}()
+	}
+	// wait until the run started before releasing it
+	for runs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("expected 200, got %d", code)
+		}
+	}
+	if runs.Load() != 1 {
+		t.Errorf("expected concurrent probes to share one run, got %d", runs.Load())
+	}
+}
+
+func TestHandler_ProbeCanceled(t *testing.T) {
+	var runs atomic.Int32
+	release := make(chan struct{})
+	h, err := health.New(health.Cfg{Checks: []health.Check{
+		{Name: "db", Critical: true, CacheTTL: time.Minute, Check: func(ctx context.Context) error {
+			runs.Add(1)
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.Readyz().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		close(done)
+	}()
+	for runs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the canceled probe to fail, got %d", rec.Code)
+	}
+
+	// the run outlives the canceled probe and its result is the one cached
+	close(release)
+	code, resp, _ := probe(t, h.Readyz(), true)
+	if code != http.StatusOK || runs.Load() != 1 {
+		t.Errorf("expected the check result to be cached, got %d %+v after %d runs", code, resp, runs.Load())
+	}
+}
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics ensures the check metrics have been initialized with NewPromMetrics, the zero value
+// records nothing.
+type Metrics struct {
+	status   *prometheus.GaugeVec
+	duration *prometheus.GaugeVec
+}
+
+// NewPromMetrics registers a gauge of the status of every check (1 passing, 0 failing) and a
+// gauge of the duration of its last run, both labelled by check name.
+func NewPromMetrics(prefix string, registry prometheus.Registerer) (Metrics, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if prefix == "" {
+		prefix = "requests"
+	}
+
+	status := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prefix,
+		Subsystem: "health_check",
+		Name:      "status",
+		Help:      "Status of the health check: 1 passing, 0 failing",
+	}, []string{"name"})
+	duration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prefix,
+		Subsystem: "health_check",
+		Name:      "duration_seconds",
+		Help:      "Duration of the last run of the health check",
+	}, []string{"name"})
+	if err := registry.Register(status); err != nil {
+		return Metrics{}, fmt.Errorf("registering prometheus gauge: %w", err)
+	}
+	if err := registry.Register(duration); err != nil {
+		return Metrics{}, fmt.Errorf("registering prometheus gauge: %w", err)
+	}
+	return Metrics{status: status, duration: duration}, nil
+}
+
+func (m Metrics) observe(name string, r result) {
+	if m.status == nil {
+		return
+	}
+	status := 0.0
+	if r.err == nil {
+		status = 1
+	}
+	m.status.WithLabelValues(name).Set(status)
+	m.duration.WithLabelValues(name).Set(r.duration.Seconds())
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// Balancer selects the upstream target of a request
+type Balancer int
+
+const (
+	// RoundRobin cycles through the healthy targets
+	RoundRobin Balancer = iota
+	// LeastConnections picks the healthy target with the fewest in-flight requests
+	LeastConnections
+	// ConsistentHash maps the HashKey of a request to the same target as long as it is healthy
+	ConsistentHash
+)
+
+// virtualNodes is the number of points per target on the consistent hash ring
+const virtualNodes = 100
+
+type ringPoint struct {
+	hash   uint32
+	target *target
+}
+
+type balancer struct {
+	kind    Balancer
+	targets []*target
+	next    atomic.Uint64
+	ring    []ringPoint
+	hashKey func(*http.Request) string
+}
+
+func newBalancer(kind Balancer, targets []*target, hashKey func(*http.Request) string) *balancer {
+	b := &balancer{kind: kind, targets: targets, hashKey: hashKey}
+	if b.hashKey == nil {
+		b.hashKey = clientIP
+	}
+	if kind == ConsistentHash {
+		for _, t := range targets {
+			for i := 0; i < virtualNodes; i++ {
+				b.ring = append(b.ring, ringPoint{hash: hash(t.url.String() + "#" + strconv.Itoa(i)), target: t})
+			}
+		}
+		slices.SortFunc(b.ring, func(a, b ringPoint) int {
+			switch {
+			case a.hash < b.hash:
+				return -1
+			case a.hash > b.hash:
+				return 1
+			}
+			return 0
+		})
+	}
+	return b
+}
+
+// pick returns a healthy target, or nil if there is none
+func (b *balancer) pick(r *http.Request) *target {
+	switch b.kind {
+	case LeastConnections:
+		var best *target
+		for _, t := range b.targets {
+			if t.healthy() && (best == nil || t.active.Load() < best.active.Load()) {
+				best = t
+			}
+		}
+		return best
+	case ConsistentHash:
+		h := hash(b.hashKey(r))
+		i, _ := slices.BinarySearchFunc(b.ring, h, func(p ringPoint, h uint32) int {
+			switch {
+			case p.hash < h:
+				return -1
+			case p.hash > h:
+				return 1
+			}
+			return 0
+		})
+		// walk the ring clockwise until a healthy target is found
+		for n := 0; n < len(b.ring); n++ {
+			if t := b.ring[(i+n)%len(b.ring)].target; t.healthy() {
+				return t
+			}
+		}
+		return nil
+	default:
+		start := b.next.Add(1)
+		for n := 0; n < len(b.targets); n++ {
+			if t := b.targets[(start+uint64(n))%uint64(len(b.targets))]; t.healthy() {
+				return t
+			}
+		}
+		return nil
+	}
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
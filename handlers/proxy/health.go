@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheckCfg configures the active health checks, they are disabled if Path is empty
+type HealthCheckCfg struct {
+	// Path is requested on every target, e.g. "/healthz"; a 2xx or 3xx response is healthy
+	Path string
+	// Interval between checks, default 10s
+	Interval time.Duration
+	// Timeout of a single check, default 2s
+	Timeout time.Duration
+}
+
+// target is an upstream with its health state
+type target struct {
+	url    *url.URL
+	active atomic.Int64 // in-flight requests
+
+	mu                  sync.Mutex
+	activeHealthy       bool      // result of the last active check
+	consecutiveFailures int       // passive failures in a row
+	ejectedUntil        time.Time // passive ejection
+}
+
+func newTarget(u *url.URL) *target {
+	return &target{url: u, activeHealthy: true}
+}
+
+func (t *target) healthy() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.activeHealthy && !time.Now().Before(t.ejectedUntil)
+}
+
+// TargetStatus describes the health of an upstream target
+type TargetStatus struct {
+	URL         string
+	Healthy     bool
+	Connections int64
+}
+
+// passiveResult records the outcome of a proxied request, after maxFailures consecutive failures
+// the target is ejected for cooldown.
+func (t *target) passiveResult(failure bool, maxFailures int, cooldown time.Duration) (ejected bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failure {
+		t.consecutiveFailures = 0
+		return false
+	}
+	t.consecutiveFailures++
+	if t.consecutiveFailures >= maxFailures {
+		t.consecutiveFailures = 0
+		t.ejectedUntil = time.Now().Add(cooldown)
+		return true
+	}
+	return false
+}
+
+func (p *Proxy) runHealthChecks(ctx context.Context) {
+	defer close(p.checksDone)
+	ticker := time.NewTicker(p.cfg.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		p.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Proxy) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range p.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			healthy := p.check(ctx, t)
+			t.mu.Lock()
+			changed := t.activeHealthy != healthy
+			t.activeHealthy = healthy
+			t.mu.Unlock()
+			if changed && p.cfg.Logger != nil {
+				p.cfg.Logger.Warn("proxy target health changed", slog.String("target", t.url.String()), slog.Bool("healthy", healthy))
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (p *Proxy) check(ctx context.Context, t *target) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.HealthCheck.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url.JoinPath(p.cfg.HealthCheck.Path).String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := p.healthClient.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
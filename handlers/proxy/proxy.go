@@ -0,0 +1,232 @@
+// Package proxy provides a load balancing reverse proxy handler based on httputil.ReverseProxy.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HeaderRules manipulates the headers of a request or response, Remove is applied before Set
+type HeaderRules struct {
+	Set    map[string]string
+	Remove []string
+}
+
+func (h HeaderRules) apply(header http.Header) {
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, v := range h.Set {
+		header.Set(name, v)
+	}
+}
+
+// Cfg configures the reverse proxy
+type Cfg struct {
+	// Targets are the upstream base URLs, e.g. "http://10.0.0.1:8080/api", at least one is required
+	Targets  []string
+	Balancer Balancer
+	// HashKey returns the key used by ConsistentHash, default the client IP
+	HashKey func(*http.Request) string
+
+	// StripPrefix is removed from the request path before it is joined to the target path, it
+	// only matches whole path segments: "/api" strips "/api/x" but not "/apiv2/x"
+	StripPrefix string
+	// PreserveHost forwards the Host header of the client instead of the host of the target,
+	// e.g. for upstreams that route by the public host name. By default the target host is used,
+	// like httputil.ProxyRequest.SetURL, as virtual hosted and TLS upstreams expect.
+	PreserveHost bool
+	// Rewrite allows further changes to the outbound request, it runs after the defaults
+	Rewrite func(*httputil.ProxyRequest)
+
+	RequestHeaders  HeaderRules
+	ResponseHeaders HeaderRules
+
+	HealthCheck HealthCheckCfg
+	// PassiveFailures ejects a target after this many consecutive connection errors or 502, 503, 504
+	// responses, default 3
+	PassiveFailures int
+	// PassiveCooldown is how long an ejected target receives no traffic, default 30s
+	PassiveCooldown time.Duration
+
+	// Transport used to reach the targets, default http.DefaultTransport
+	Transport http.RoundTripper
+	// FlushInterval is passed to httputil.ReverseProxy, streamed responses are always flushed immediately
+	FlushInterval time.Duration
+	Logger        *slog.Logger
+}
+
+// Proxy forwards requests to a set of upstream targets. Upstream connection errors are answered
+// with http.Error, so they are rendered by the error middleware like any other handler error.
+// WebSocket and other upgrade requests are passed through.
+type Proxy struct {
+	cfg          Cfg
+	targets      []*target
+	balancer     *balancer
+	rp           *httputil.ReverseProxy
+	healthClient *http.Client
+	cancel       context.CancelFunc
+	checksDone   chan struct{}
+}
+
+type targetKey struct{}
+
+const statusClientClosedRequest = 499
+
+// New creates a Proxy, if active health checks are configured they start immediately and run
+// until Close is called.
+func New(cfg Cfg) (*Proxy, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, errors.New("at least one target is required")
+	}
+	if cfg.PassiveFailures == 0 {
+		cfg.PassiveFailures = 3
+	}
+	if cfg.PassiveCooldown == 0 {
+		cfg.PassiveCooldown = 30 * time.Second
+	}
+	if cfg.HealthCheck.Interval == 0 {
+		cfg.HealthCheck.Interval = 10 * time.Second
+	}
+	if cfg.HealthCheck.Timeout == 0 {
+		cfg.HealthCheck.Timeout = 2 * time.Second
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = http.DefaultTransport
+	}
+
+	p := &Proxy{cfg: cfg, healthClient: &http.Client{Transport: cfg.Transport}}
+	for _, raw := range cfg.Targets {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", raw, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid target %q: scheme and host are required", raw)
+		}
+		p.targets = append(p.targets, newTarget(u))
+	}
+	p.balancer = newBalancer(cfg.Balancer, p.targets, cfg.HashKey)
+
+	p.rp = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		Transport:      cfg.Transport,
+		FlushInterval:  cfg.FlushInterval,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+
+	if cfg.HealthCheck.Path != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.cancel = cancel
+		p.checksDone = make(chan struct{})
+		go p.runHealthChecks(ctx)
+	}
+	return p, nil
+}
+
+// Close stops the active health checks
+func (p *Proxy) Close() {
+	if p.cancel != nil {
+		p.cancel()
+		<-p.checksDone
+	}
+}
+
+// Targets returns the current state of the upstream targets
+func (p *Proxy) Targets() []TargetStatus {
+	out := make([]TargetStatus, 0, len(p.targets))
+	for _, t := range p.targets {
+		out = append(out, TargetStatus{URL: t.url.String(), Healthy: t.healthy(), Connections: t.active.Load()})
+	}
+	return out
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := p.balancer.pick(r)
+	if t == nil {
+		http.Error(w, "no healthy upstream", http.StatusServiceUnavailable)
+		return
+	}
+	t.active.Add(1)
+	defer t.active.Add(-1)
+	p.rp.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), targetKey{}, t)))
+}
+
+func (p *Proxy) rewrite(pr *httputil.ProxyRequest) {
+	t := pr.In.Context().Value(targetKey{}).(*target)
+	if p.cfg.StripPrefix != "" {
+		if path, ok := stripPrefix(pr.Out.URL.Path, p.cfg.StripPrefix); ok {
+			// strip the escaped path as well, so encoded characters like %2F reach the upstream
+			// unchanged; RawPath is only used by the URL if it still matches Path
+			rawPath, _ := stripPrefix(pr.Out.URL.EscapedPath(), (&url.URL{Path: p.cfg.StripPrefix}).EscapedPath())
+			pr.Out.URL.Path = path
+			pr.Out.URL.RawPath = rawPath
+		}
+	}
+	pr.SetURL(t.url)
+	pr.SetXForwarded()
+	if p.cfg.PreserveHost {
+		pr.Out.Host = pr.In.Host
+	}
+	p.cfg.RequestHeaders.apply(pr.Out.Header)
+	if p.cfg.Rewrite != nil {
+		p.cfg.Rewrite(pr)
+	}
+}
+
+// stripPrefix removes prefix from path if it matches whole path segments
+func stripPrefix(path, prefix string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return path, false
+	}
+	if rest == "" {
+		return "/", true
+	}
+	return rest, true
+}
+
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	if t, ok := resp.Request.Context().Value(targetKey{}).(*target); ok {
+		failure := resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable ||
+			resp.StatusCode == http.StatusGatewayTimeout
+		p.passive(t, failure)
+	}
+	p.cfg.ResponseHeaders.apply(resp.Header)
+	return nil
+}
+
+func (p *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the client went away, nothing to render; 499 follows the nginx convention for the logs
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	if t, ok := r.Context().Value(targetKey{}).(*target); ok {
+		p.passive(t, true)
+	}
+	if p.cfg.Logger != nil {
+		p.cfg.Logger.Warn("proxy upstream error", slog.String("url", r.RequestURI), slog.Any("err", err))
+	}
+	status := http.StatusBadGateway
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (p *Proxy) passive(t *target, failure bool) {
+	if t.passiveResult(failure, p.cfg.PassiveFailures, p.cfg.PassiveCooldown) && p.cfg.Logger != nil {
+		p.cfg.Logger.Warn("proxy target ejected", slog.String("target", t.url.String()),
+			slog.Duration("cooldown", p.cfg.PassiveCooldown))
+	}
+}
@@ -0,0 +1,268 @@
+package proxy_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/handlers/proxy"
+	"github.com/go-bumbu/http/middleware"
+)
+
+// newUpstream returns a server that responds with its name, the escaped path and the X-Test header
+// it received
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Internal", "secret")
+		_, _ = fmt.Fprintf(w, "%s %s %s", name, r.URL.EscapedPath(), r.Header.Get("X-Test"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, h http.Handler, path string, remoteAddr string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestProxy_RewriteAndHeaders(t *testing.T) {
+	up := newUpstream(t, "a")
+	p, err := proxy.New(proxy.Cfg{
+		Targets:         []string{up.URL + "/base"},
+		StripPrefix:     "/api",
+		RequestHeaders:  proxy.HeaderRules{Set: map[string]string{"X-Test": "1"}},
+		ResponseHeaders: proxy.HeaderRules{Remove: []string{"X-Internal"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get(t, p, "/api/users", "")
+	if rec.Body.String() != "a /base/users 1" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+	if rec.Header().Get("X-Internal") != "" {
+		t.Errorf("expected response header to be removed")
+	}
+}
+
+func TestProxy_StripPrefixSegments(t *testing.T) {
+	up := newUpstream(t, "a")
+	p, err := proxy.New(proxy.Cfg{Targets: []string{up.URL}, StripPrefix: "/api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := map[string]string{
+		"/api":     "a / ",
+		"/api/":    "a / ",
+		"/api/x":   "a /x ",
+		"/apiv2/x": "a /apiv2/x ",
+		"/other":   "a /other ",
+		// encoded slashes are kept
+		"/api/a%2Fb":   "a /a%2Fb ",
+		"/other/a%2Fb": "a /other/a%2Fb ",
+	}
+	for path, want := range tcs {
+		if rec := get(t, p, path, ""); rec.Body.String() != want {
+			t.Errorf("%s: expected %q, got %q", path, want, rec.Body.String())
+		}
+	}
+}
+
+func TestProxy_Host(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.Host)
+	}))
+	t.Cleanup(up.Close)
+	upHost := strings.TrimPrefix(up.URL, "http://")
+
+	for _, preserve := range []bool{false, true} {
+		p, err := proxy.New(proxy.Cfg{Targets: []string{up.URL}, PreserveHost: preserve})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := upHost
+		if preserve {
+			want = "public.example.com"
+		}
+		if rec := get(t, p, "http://public.example.com/", ""); rec.Body.String() != want {
+			t.Errorf("preserve %v: expected host %q, got %q", preserve, want, rec.Body.String())
+		}
+	}
+}
+
+func TestProxy_Balancers(t *testing.T) {
+	a, b := newUpstream(t, "a"), newUpstream(t, "b")
+
+	t.Run("round robin", func(t *testing.T) {
+		p, _ := proxy.New(proxy.Cfg{Targets: []string{a.URL, b.URL}})
+		seen := map[string]int{}
+		for i := 0; i < 4; i++ {
+			seen[strings.Fields(get(t, p, "/", "").Body.String())[0]]++
+		}
+		if seen["a"] != 2 || seen["b"] != 2 {
+			t.Errorf("expected even distribution, got %v", seen)
+		}
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		p, _ := proxy.New(proxy.Cfg{Targets: []string{a.URL, b.URL}, Balancer: proxy.ConsistentHash})
+		for _, ip := range []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.3:1"} {
+			first := get(t, p, "/", ip).Body.String()
+			for i := 0; i < 3; i++ {
+				if got := get(t, p, "/", ip).Body.String(); got != first {
+					t.Errorf("expected %s to stick to one target, got %q and %q", ip, first, got)
+				}
+			}
+		}
+	})
+
+	t.Run("least connections", func(t *testing.T) {
+		release := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			_, _ = fmt.Fprint(w, "slow")
+		}))
+		defer slow.Close()
+		defer close(release)
+
+		p, _ := proxy.New(proxy.Cfg{Targets: []string{slow.URL, a.URL}, Balancer: proxy.LeastConnections})
+		go get(t, p, "/", "")
+		for p.Targets()[0].Connections == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if got := get(t, p, "/", "").Body.String(); !strings.HasPrefix(got, "a") {
+			t.Errorf("expected the idle target, got %q", got)
+		}
+	})
+}
+
+func TestProxy_PassiveHealth(t *testing.T) {
+	a := newUpstream(t, "a")
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downURL := down.URL
+	down.Close()
+
+	p, _ := proxy.New(proxy.Cfg{Targets: []string{downURL, a.URL}, PassiveFailures: 1, PassiveCooldown: time.Minute})
+	for i := 0; i < 4; i++ {
+		get(t, p, "/", "")
+	}
+	if p.Targets()[0].Healthy {
+		t.Errorf("expected unreachable target to be ejected")
+	}
+	for i := 0; i < 3; i++ {
+		if rec := get(t, p, "/", ""); rec.Code != http.StatusOK {
+			t.Errorf("expected traffic to go to the healthy target, got %d", rec.Code)
+		}
+	}
+}
+
+func TestProxy_ActiveHealth(t *testing.T) {
+	var healthy atomic.Bool
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer up.Close()
+
+	p, _ := proxy.New(proxy.Cfg{Targets: []string{up.URL}, HealthCheck: proxy.HealthCheckCfg{Path: "/healthz", Interval: 5 * time.Millisecond}})
+	defer p.Close()
+
+	waitFor := func(want bool) {
+		t.Helper()
+		for i := 0; i < 200 && p.Targets()[0].Healthy != want; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if p.Targets()[0].Healthy != want {
+			t.Fatalf("expected healthy=%v", want)
+		}
+	}
+	waitFor(false)
+	if rec := get(t, p, "/", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without healthy targets, got %d", rec.Code)
+	}
+	healthy.Store(true)
+	waitFor(true)
+}
+
+func TestProxy_ErrorsRenderedByMiddleware(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downURL := down.URL
+	down.Close()
+
+	p, _ := proxy.New(proxy.Cfg{Targets: []string{downURL}})
+	h := middleware.New(middleware.Cfg{JsonErrors: true}).Middleware(p)
+	rec := get(t, h, "/", "")
+
+	var payload struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("expected json error, got %q", rec.Body.String())
+	}
+	if payload.Code != http.StatusBadGateway || rec.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d %+v", rec.Code, payload)
+	}
+}
+
+func TestProxy_WebSocket(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = brw.Flush()
+		// echo
+		_, _ = io.Copy(conn, brw)
+	}))
+	defer up.Close()
+
+	p, _ := proxy.New(proxy.Cfg{Targets: []string{up.URL}})
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	_, _ = fmt.Fprint(conn, "ping")
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("expected echo through the tunnel, got %q %v", buf, err)
+	}
+}
@@ -0,0 +1,313 @@
+// Package sse implements a Server-Sent Events broker with topics, bounded replay history,
+// heartbeats and backpressure handling for slow clients.
+package sse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a single server-sent event
+type Event struct {
+	// ID is assigned by the broker on Publish, it is used by clients to resume with Last-Event-ID
+	ID string
+	// Event is the event type, empty means "message"
+	Event string
+	// Data is sent as one data line per line of text
+	Data string
+}
+
+// Policy defines what happens when a client does not keep up and its buffer is full
+type Policy int
+
+const (
+	// DropOldest discards the oldest buffered event to make room for the new one
+	DropOldest Policy = iota
+	// DropNewest discards the new event
+	DropNewest
+	// Disconnect closes the stream, the client can reconnect and resume with Last-Event-ID
+	Disconnect
+)
+
+// Cfg configures the broker
+type Cfg struct {
+	// BufferSize is the number of events buffered per client, default 64
+	BufferSize int
+	// Policy applied when a client buffer is full, default DropOldest
+	Policy Policy
+	// HistorySize is the number of events kept to replay on reconnect, default 100, -1 disables replay
+	HistorySize int
+	// Heartbeat is the interval of the comment lines keeping idle connections open, default 15s
+	Heartbeat time.Duration
+	// Retry is sent to clients as reconnection delay if set
+	Retry time.Duration
+	// Topics returns the topics a request subscribes to, default the "topic" query parameters
+	Topics func(r *http.Request) []string
+	Logger *slog.Logger
+}
+
+// Broker fans out published events to the subscribed clients, it is an http.Handler serving the
+// event stream. Flushing goes through http.ResponseController, so it works behind the middleware
+// of this module; the request is logged once when the stream closes.
+type Broker struct {
+	cfg Cfg
+
+	mu      sync.Mutex
+	seq     uint64
+	history []stored
+	clients map[*client]struct{}
+	dropped uint64
+	closed  bool
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+type stored struct {
+	seq   uint64
+	topic string
+	event Event
+}
+
+type client struct {
+	topics map[string]bool
+	events chan Event
+	kicked chan struct{}
+	once   sync.Once
+}
+
+func (c *client) kick() {
+	c.once.Do(func() { close(c.kicked) })
+}
+
+func NewBroker(cfg Cfg) *Broker {
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = 64
+	}
+	if cfg.HistorySize == 0 {
+		cfg.HistorySize = 100
+	}
+	if cfg.Heartbeat == 0 {
+		cfg.Heartbeat = 15 * time.Second
+	}
+	if cfg.Topics == nil {
+		cfg.Topics = func(r *http.Request) []string { return r.URL.Query()["topic"] }
+	}
+	return &Broker{
+		cfg:     cfg,
+		clients: map[*client]struct{}{},
+		closing: make(chan struct{}),
+	}
+}
+
+// Publish sends an event to all clients subscribed to topic and returns the assigned event ID
+func (b *Broker) Publish(topic string, e Event) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ""
+	}
+	b.seq++
+	e.ID = strconv.FormatUint(b.seq, 10)
+	if b.cfg.HistorySize > 0 {
+		if len(b.history) >= b.cfg.HistorySize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, stored{seq: b.seq, topic: topic, event: e})
+	}
+	for c := range b.clients {
+		if c.topics[topic] {
+			b.deliver(c, e)
+		}
+	}
+	return e.ID
+}
+
+// deliver needs to be called with the lock held
+func (b *Broker) deliver(c *client, e Event) {
+	select {
+	case c.events <- e:
+		return
+	default:
+	}
+	b.dropped++
+	switch b.cfg.Policy {
+	case DropOldest:
+		select {
+		case <-c.events:
+		default:
+		}
+		select {
+		case c.events <- e:
+		default:
+		}
+	case Disconnect:
+		c.kick()
+	}
+}
+
+// Clients returns the number of connected clients
+func (b *Broker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// Dropped returns the number of events not delivered because of full client buffers
+func (b *Broker) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// Shutdown closes all streams and rejects new ones, it waits for the handlers to return or ctx
+// to be done. Call it before http.Server.Shutdown, which does not interrupt active streams.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.closing)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// subscribe registers a client and returns the history events after lastID to replay
+func (b *Broker) subscribe(topics []string, lastID string) (*client, []Event, bool) {
+	c := &client{
+		topics: map[string]bool{},
+		events: make(chan Event, b.cfg.BufferSize),
+		kicked: make(chan struct{}),
+	}
+	for _, t := range topics {
+		c.topics[t] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil, false
+	}
+	var replay []Event
+	if last, err := strconv.ParseUint(lastID, 10, 64); err == nil {
+		for _, s := range b.history {
+			if s.seq > last && c.topics[s.topic] {
+				replay = append(replay, s.event)
+			}
+		}
+	}
+	b.clients[c] = struct{}{}
+	b.wg.Add(1)
+	return c, replay, true
+}
+
+func (b *Broker) unsubscribe(c *client) {
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+	b.wg.Done()
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	topics := b.cfg.Topics(r)
+	if len(topics) == 0 {
+		http.Error(w, "no topic", http.StatusBadRequest)
+		return
+	}
+	c, replay, ok := b.subscribe(topics, r.Header.Get("Last-Event-ID"))
+	if !ok {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer b.unsubscribe(c)
+
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if b.cfg.Retry > 0 {
+		_, _ = fmt.Fprintf(w, "retry: %d\n\n", b.cfg.Retry.Milliseconds())
+	}
+	for _, e := range replay {
+		if writeEvent(w, e) != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		b.logErr(r, "streaming not supported", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(b.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.closing:
+			return
+		case <-c.kicked:
+			return
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": ping\n\n")
+		case e := <-c.events:
+			err = writeEvent(w, e)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *Broker) logErr(r *http.Request, msg string, err error) {
+	if b.cfg.Logger != nil {
+		b.cfg.Logger.ErrorContext(r.Context(), msg, slog.Any("err", err), slog.String("url", r.RequestURI))
+	}
+}
+
+// lineBreaks removes line breaks from single line fields, so they can't inject fields
+var lineBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// normalizeBreaks converts all line endings of the data to \n
+var normalizeBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+func writeEvent(w io.Writer, e Event) error {
+	var sb strings.Builder
+	if e.ID != "" {
+		sb.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		sb.WriteString("event: " + lineBreaks.Replace(e.Event) + "\n")
+	}
+	for _, line := range strings.Split(normalizeBreaks.Replace(e.Data), "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
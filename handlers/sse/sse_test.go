@@ -0,0 +1,226 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// syncBuf is a bytes.Buffer safe for the concurrent writes of the server and reads of the test
+type syncBuf struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuf) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuf) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+// connect opens a stream and returns a function reading the next non empty block of lines
+func connect(t *testing.T, url, lastID string) (func() string, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+	br := bufio.NewReader(resp.Body)
+	next := func() string {
+		var lines []string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return strings.Join(lines, "|")
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				if len(lines) > 0 {
+					return strings.Join(lines, "|")
+				}
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+	return next, func() {
+		cancel()
+		_ = resp.Body.Close()
+	}
+}
+
+func waitClients(t *testing.T, b *Broker, n int) {
+	t.Helper()
+	for i := 0; i < 200 && b.Clients() != n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if b.Clients() != n {
+		t.Fatalf("expected %d clients, got %d", n, b.Clients())
+	}
+}
+
+func TestBroker_PublishThroughMiddleware(t *testing.T) {
+	var logs syncBuf
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	b := NewBroker(Cfg{})
+	srv := httptest.NewServer(middleware.New(middleware.Cfg{Logger: logger, JsonErrors: true}).Middleware(b))
+	defer srv.Close()
+
+	next, closeStream := connect(t, srv.URL+"?topic=news", "")
+	waitClients(t, b, 1)
+
+	b.Publish("other", Event{Data: "ignored"})
+	b.Publish("news", Event{Event: "update", Data: "line1\nline2"})
+	if got := next(); got != "id: 2|event: update|data: line1|data: line2" {
+		t.Errorf("unexpected event %q", got)
+	}
+	if logs.String() != "" {
+		t.Errorf("expected no log line while streaming, got %q", logs.String())
+	}
+
+	closeStream()
+	waitClients(t, b, 0)
+	for i := 0; i < 200 && logs.String() == ""; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := strings.Count(logs.String(), "\n"); n != 1 || !strings.Contains(logs.String(), "response-code=200") {
+		t.Errorf("expected a single log line on close, got %q", logs.String())
+	}
+}
+
+func TestBroker_Replay(t *testing.T) {
+	b := NewBroker(Cfg{HistorySize: 3})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	for _, d := range []string{"a", "b", "c", "d"} {
+		b.Publish("news", Event{Data: d})
+	}
+	b.Publish("other", Event{Data: "x"})
+
+	next, closeStream := connect(t, srv.URL+"?topic=news", "2")
+	defer closeStream()
+	// event 1 is out of the history, 2 was already received
+	if got := next(); got != "id: 3|data: c" {
+		t.Errorf("unexpected replay %q", got)
+	}
+	if got := next(); got != "id: 4|data: d" {
+		t.Errorf("unexpected replay %q", got)
+	}
+}
+
+func TestBroker_Heartbeat(t *testing.T) {
+	b := NewBroker(Cfg{Heartbeat: 5 * time.Millisecond, Retry: time.Second})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	next, closeStream := connect(t, srv.URL+"?topic=news", "")
+	defer closeStream()
+	if got := next(); got != "retry: 1000" {
+		t.Errorf("expected retry, got %q", got)
+	}
+	if got := next(); got != ": ping" {
+		t.Errorf("expected heartbeat, got %q", got)
+	}
+}
+
+func TestBroker_Shutdown(t *testing.T) {
+	b := NewBroker(Cfg{})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	next, closeStream := connect(t, srv.URL+"?topic=news", "")
+	defer closeStream()
+	waitClients(t, b, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != "" {
+		t.Errorf("expected stream to end, got %q", got)
+	}
+	resp, err := http.Get(srv.URL + "?topic=news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after shutdown, got %d", resp.StatusCode)
+	}
+}
+
+func TestBroker_Backpressure(t *testing.T) {
+	tcs := []struct {
+		name   string
+		policy Policy
+		expect []string
+		kicked bool
+	}{
+		{name: "drop oldest", policy: DropOldest, expect: []string{"2", "3"}},
+		{name: "drop newest", policy: DropNewest, expect: []string{"1", "2"}},
+		{name: "disconnect", policy: Disconnect, expect: []string{"1", "2"}, kicked: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBroker(Cfg{BufferSize: 2, Policy: tc.policy})
+			c, _, _ := b.subscribe([]string{"news"}, "")
+			for _, d := range []string{"1", "2", "3"} {
+				b.Publish("news", Event{Data: d})
+			}
+			close(c.events)
+			var got []string
+			for e := range c.events {
+				got = append(got, e.Data)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.expect, ",") {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+			select {
+			case <-c.kicked:
+				if !tc.kicked {
+					t.Error("unexpected disconnect")
+				}
+			default:
+				if tc.kicked {
+					t.Error("expected disconnect")
+				}
+			}
+			if b.Dropped() != 1 {
+				t.Errorf("expected 1 dropped event, got %d", b.Dropped())
+			}
+		})
+	}
+}
+
+func TestWriteEvent_NoInjection(t *testing.T) {
+	var buf bytes.Buffer
+	_ = writeEvent(&buf, Event{Event: "a\nid: 99", Data: "x\r\ndata: y"})
+	if buf.String() != "event: aid: 99\ndata: x\ndata: data: y\n\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
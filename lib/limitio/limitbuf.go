@@ -23,6 +23,9 @@ func (b *LimitedBuf) Reset() {
 func (b *LimitedBuf) Write(p []byte) (n int, err error) {
 	remaining := b.MaxBytes - b.curByte
 	if remaining <= 0 {
+		if len(p) > 0 {
+			b.truncated = true
+		}
 		return 0, ErrBufferLimit
 	}
 	if len(p) > remaining {
@@ -167,3 +167,15 @@ func TestLimitWriter_MultipleWrites(t *testing.T) {
 		t.Errorf("expected %q, got %q", "HelloWor", buf.String())
 	}
 }
+
+func TestBuffer_TruncatedAfterExactFill(t *testing.T) {
+	buf := &limitio.LimitedBuf{MaxBytes: 5}
+	_, _ = buf.Write([]byte("Hello"))
+	if buf.Truncated() {
+		t.Error("expected Truncated() to be false when the limit is reached exactly")
+	}
+	_, _ = buf.Write([]byte("!"))
+	if !buf.Truncated() {
+		t.Error("expected Truncated() to be true after writing past a full buffer")
+	}
+}
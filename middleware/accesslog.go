@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-bumbu/http/middleware/auth"
+)
+
+// AccessLogFormat selects the line format of the access log
+type AccessLogFormat int
+
+const (
+	// FormatCommon is the NCSA Common Log Format
+	FormatCommon AccessLogFormat = iota
+	// FormatCombined is the Apache/NCSA Combined Log Format, Common plus referer and user agent
+	FormatCombined
+	// FormatW3C is the W3C extended log format, the fields are defined by AccessLogCfg.Fields
+	FormatW3C
+	// FormatJSON writes one JSON object per line with ECS / OpenTelemetry semantic convention field names
+	FormatJSON
+)
+
+// DefaultW3CFields are the W3C extended log fields used if none are configured
+var DefaultW3CFields = []string{"date", "time", "c-ip", "cs-username", "cs-method", "cs-uri-stem", "cs-uri-query",
+	"sc-status", "sc-bytes", "cs-bytes", "time-taken", "cs(User-Agent)", "cs(Referer)"}
+
+// AccessLogCfg configures the access log
+type AccessLogCfg struct {
+	Format AccessLogFormat
+	// Fields is the W3C field template, supported are date, time, c-ip, cs-username, cs-method,
+	// cs-uri, cs-uri-stem, cs-uri-query, cs-version, sc-status, sc-bytes, cs-bytes, time-taken,
+	// cs(Header) and sc(Header). Default DefaultW3CFields
+	Fields []string
+	// BufferSize is the number of lines queued for the writer, lines are dropped when the queue is full, default 1024
+	BufferSize int
+}
+
+// AccessLog writes one line per request to an io.Writer. Lines are written asynchronously and
+// buffered, so a slow writer never blocks requests; when the queue is full the line is dropped
+// and counted, see Dropped. Close needs to be called to flush the pending lines.
+type AccessLog struct {
+	cfg     AccessLogCfg
+	w       *bufio.Writer
+	lines   chan []byte
+	dropped atomic.Uint64
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	err     error
+}
+
+func NewAccessLog(w io.Writer, cfg AccessLogCfg) *AccessLog {
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = 1024
+	}
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = DefaultW3CFields
+	}
+	a := &AccessLog{
+		cfg:   cfg,
+		w:     bufio.NewWriter(w),
+		lines: make(chan []byte, cfg.BufferSize),
+		done:  make(chan struct{}),
+	}
+	if cfg.Format == FormatW3C {
+		a.lines <- []byte("#Version: 1.0\n#Fields: " + strings.Join(cfg.Fields, " ") + "\n")
+	}
+	go a.run()
+	return a
+}
+
+func (a *AccessLog) run() {
+	defer close(a.done)
+	for line := range a.lines {
+		if _, err := a.w.Write(line); err != nil && a.err == nil {
+			a.err = err
+		}
+		// flush once the queue is drained, so bursts are written in one go
+		if len(a.lines) == 0 {
+			if err := a.w.Flush(); err != nil && a.err == nil {
+				a.err = err
+			}
+		}
+	}
+	if err := a.w.Flush(); err != nil && a.err == nil {
+		a.err = err
+	}
+}
+
+// Dropped returns the number of lines dropped because the queue was full
+func (a *AccessLog) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close writes the pending lines and returns the first write error, requests served after
+// Close are not logged.
+func (a *AccessLog) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.lines)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return a.err
+}
+
+// Middleware logs every request handled by next
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		respWriter := NewWriter(w, false, false)
+		r = r.WithContext(auth.Track(r.Context()))
+		respWriter.CountBody(r)
+
+		next.ServeHTTP(respWriter, r)
+
+		e := accessEntry{r: r, sw: respWriter, start: start, dur: time.Since(start)}
+		if p, ok := auth.FromContext(r.Context()); ok {
+			e.user = p.Name
+		}
+		a.enqueue(a.format(e))
+	})
+}
+
+func (a *AccessLog) enqueue(line []byte) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.lines <- line:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+type accessEntry struct {
+	r     *http.Request
+	sw    *StatWriter
+	start time.Time
+	dur   time.Duration
+	user  string
+}
+
+func (e accessEntry) bytesWritten() int64 {
+	if e.sw.Hijacked() {
+		_, written := e.sw.HijackedBytes()
+		return written
+	}
+	return e.sw.BytesWritten()
+}
+
+func (a *AccessLog) format(e accessEntry) []byte {
+	switch a.cfg.Format {
+	case FormatW3C:
+		return a.formatW3C(e)
+	case FormatJSON:
+		return formatJSON(e)
+	default:
+		return formatCLF(e, a.cfg.Format == FormatCombined)
+	}
+}
+
+// formatCLF writes: host ident authuser [date] "request" status bytes ["referer" "user-agent"]
+func formatCLF(e accessEntry, combined bool) []byte {
+	var sb strings.Builder
+	sb.WriteString(clfString(clientHost(e.r)) + " - " + clfString(e.user))
+	sb.WriteString(" [" + e.start.Format("02/Jan/2006:15:04:05 -0700") + "] ")
+	sb.WriteString(strconv.Quote(e.r.Method + " " + e.r.RequestURI + " " + e.r.Proto))
+	sb.WriteString(" " + strconv.Itoa(e.sw.StatusCode()) + " ")
+	if n := e.bytesWritten(); n > 0 {
+		sb.WriteString(strconv.FormatInt(n, 10))
+	} else {
+		sb.WriteString("-")
+	}
+	if combined {
+		sb.WriteString(" " + strconv.Quote(dashIfEmpty(e.r.Referer())) + " " + strconv.Quote(dashIfEmpty(e.r.UserAgent())))
+	}
+	sb.WriteString("\n")
+	return []byte(sb.String())
+}
+
+func (a *AccessLog) formatW3C(e accessEntry) []byte {
+	values := make([]string, len(a.cfg.Fields))
+	for i, field := range a.cfg.Fields {
+		values[i] = w3cValue(e, field)
+	}
+	return []byte(strings.Join(values, " ") + "\n")
+}
+
+func w3cValue(e accessEntry, field string) string {
+	switch field {
+	case "date":
+		return e.start.UTC().Format("2006-01-02")
+	case "time":
+		return e.start.UTC().Format("15:04:05")
+	case "c-ip":
+		return w3cString(clientHost(e.r))
+	case "cs-username":
+		return w3cString(e.user)
+	case "cs-method":
+		return e.r.Method
+	case "cs-uri":
+		return w3cString(e.r.RequestURI)
+	case "cs-uri-stem":
+		return w3cString(e.r.URL.Path)
+	case "cs-uri-query":
+		return w3cString(e.r.URL.RawQuery)
+	case "cs-version":
+		return e.r.Proto
+	case "sc-status":
+		return strconv.Itoa(e.sw.StatusCode())
+	case "sc-bytes":
+		return strconv.FormatInt(e.bytesWritten(), 10)
+	case "cs-bytes":
+		return strconv.FormatInt(e.sw.BytesRead(), 10)
+	case "time-taken":
+		return strconv.FormatFloat(e.dur.Seconds(), 'f', 3, 64)
+	}
+	if name, ok := strings.CutPrefix(field, "cs("); ok {
+		return w3cString(e.r.Header.Get(strings.TrimSuffix(name, ")")))
+	}
+	if name, ok := strings.CutPrefix(field, "sc("); ok {
+		return w3cString(e.sw.Header().Get(strings.TrimSuffix(name, ")")))
+	}
+	return "-"
+}
+
+// clfString replaces empty values with "-" and escapes the bytes that could break up the unquoted
+// fields of a CLF line (spaces, quotes, control and non-ASCII characters) as \xHH, as Apache does.
+// The host can come from a client controlled header, the user from the credentials.
+func clfString(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' || c == '[' || c == ']' {
+			_, _ = fmt.Fprintf(&sb, `\x%02x`, c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// w3cString replaces empty values with "-" and spaces with "+", as IIS does
+func w3cString(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == ' ' {
+			return '+'
+		}
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// formatJSON uses the ECS field names, which are shared with the OpenTelemetry semantic conventions
+func formatJSON(e accessEntry) []byte {
+	fields := map[string]any{
+		"@timestamp":                e.start.UTC().Format(time.RFC3339Nano),
+		"http.request.method":       e.r.Method,
+		"url.path":                  e.r.URL.Path,
+		"http.response.status_code": e.sw.StatusCode(),
+		"http.request.body.size":    e.sw.BytesRead(),
+		"http.response.body.size":   e.bytesWritten(),
+		"client.address":            clientHost(e.r),
+		"event.duration":            e.dur.Nanoseconds(),
+		"network.protocol.version":  strings.TrimPrefix(e.r.Proto, "HTTP/"),
+	}
+	optional := map[string]string{
+		"url.query":             e.r.URL.RawQuery,
+		"user_agent.original":   e.r.UserAgent(),
+		"http.request.referrer": e.r.Referer(),
+		"http.request.id":       e.r.Header.Get(HeaderRequestID),
+		"user.name":             e.user,
+	}
+	for k, v := range optional {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	b, _ := json.Marshal(fields)
+	return append(b, '\n')
+}
+
+// clientHost returns the client address without port, of a forwarded list the first one
+func clientHost(r *http.Request) string {
+	ip, _, _ := strings.Cut(userIp(r), ",")
+	ip = strings.TrimSpace(ip)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		return host
+	}
+	return ip
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
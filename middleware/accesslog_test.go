@@ -0,0 +1,149 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+	"github.com/go-bumbu/http/middleware/auth"
+)
+
+func serveAccessLog(t *testing.T, cfg middleware.AccessLogCfg, req *http.Request) string {
+	t.Helper()
+	var buf bytes.Buffer
+	al := middleware.NewAccessLog(&buf, cfg)
+	authn := auth.Middleware(auth.Basic{Verifier: auth.StaticUsers{"bob": "secret", "eve \"x\"\n": "secret"}})
+	h := al.Middleware(authn(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func newAccessLogRequest() *http.Request {
+	req := httptest.NewRequest("GET", "/path?q=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.SetBasicAuth("bob", "secret")
+	req.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	req.Header.Set("Referer", "https://example.com/")
+	return req
+}
+
+func TestAccessLog_Formats(t *testing.T) {
+	tcs := []struct {
+		name   string
+		cfg    middleware.AccessLogCfg
+		expect string // regular expression
+	}{
+		{
+			name:   "common",
+			cfg:    middleware.AccessLogCfg{Format: middleware.FormatCommon},
+			expect: `^192\.0\.2\.1 - bob \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /path\?q=1 HTTP/1\.1" 200 5\n$`,
+		},
+		{
+			name: "combined",
+			cfg:  middleware.AccessLogCfg{Format: middleware.FormatCombined},
+			expect: `^192\.0\.2\.1 - bob \[.+\] "GET /path\?q=1 HTTP/1\.1" 200 5 ` +
+				`"https://example\.com/" "curl/8\.0 \\"quoted\\""\n$`,
+		},
+		{
+			name: "w3c",
+			cfg: middleware.AccessLogCfg{Format: middleware.FormatW3C,
+				Fields: []string{"date", "c-ip", "cs-username", "cs-method", "cs-uri-stem", "cs-uri-query", "sc-status", "sc-bytes", "cs(User-Agent)", "sc(Content-Type)", "x-unknown"}},
+			expect: `^#Version: 1\.0\n#Fields: date c-ip cs-username cs-method cs-uri-stem cs-uri-query sc-status sc-bytes cs\(User-Agent\) sc\(Content-Type\) x-unknown\n` +
+				`\d{4}-\d{2}-\d{2} 192\.0\.2\.1 bob GET /path q=1 200 5 curl/8\.0\+"quoted" text/plain -\n$`,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := serveAccessLog(t, tc.cfg, newAccessLogRequest())
+			if !regexp.MustCompile(tc.expect).MatchString(got) {
+				t.Errorf("log line %q does not match %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestAccessLog_Injection(t *testing.T) {
+	newReq := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", "1.2.3.4 - evil [01/Jan/2000:00:00:00 +0000] \"GET / HTTP/1.1\" 200 0\nnext")
+		req.SetBasicAuth("eve \"x\"\n", "secret")
+		return req
+	}
+
+	got := serveAccessLog(t, middleware.AccessLogCfg{Format: middleware.FormatCommon}, newReq())
+	expect := `^1\.2\.3\.4\\x20-\\x20evil\\x20\\x5b.*\\x0anext - eve\\x20\\x22x\\x22\\x0a \[.+\] "GET / HTTP/1\.1" 200 5\n$`
+	if !regexp.MustCompile(expect).MatchString(got) {
+		t.Errorf("log line %q does not match %q", got, expect)
+	}
+
+	got = serveAccessLog(t, middleware.AccessLogCfg{Format: middleware.FormatW3C, Fields: []string{"c-ip", "cs-username", "sc-status"}}, newReq())
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 3 || len(strings.Fields(lines[2])) != 3 {
+		t.Errorf("expected a single entry with 3 fields, got %q", got)
+	}
+}
+
+func TestAccessLog_JSON(t *testing.T) {
+	got := serveAccessLog(t, middleware.AccessLogCfg{Format: middleware.FormatJSON}, newAccessLogRequest())
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(got), &fields); err != nil {
+		t.Fatalf("expected json line, got %q", got)
+	}
+	expect := map[string]any{
+		"http.request.method":       "GET",
+		"url.path":                  "/path",
+		"url.query":                 "q=1",
+		"http.response.status_code": float64(200),
+		"http.response.body.size":   float64(5),
+		"client.address":            "192.0.2.1",
+		"user.name":                 "bob",
+		"user_agent.original":       `curl/8.0 "quoted"`,
+	}
+	for k, v := range expect {
+		if fields[k] != v {
+			t.Errorf("expected %s=%v, got %v", k, v, fields[k])
+		}
+	}
+	if _, ok := fields["@timestamp"]; !ok {
+		t.Error("expected @timestamp")
+	}
+}
+
+// blockingWriter blocks every write until release is closed
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+func TestAccessLog_DropOnFull(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	// the bufio writer passes lines larger than its buffer straight to the blocking writer
+	al := middleware.NewAccessLog(w, middleware.AccessLogCfg{BufferSize: 2})
+	h := al.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	path := "/" + strings.Repeat("x", 5000)
+	for i := 0; i < 10; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	close(w.release)
+	_ = al.Close()
+	// one line is being written, two are queued
+	if al.Dropped() < 7 {
+		t.Errorf("expected lines to be dropped, got %d", al.Dropped())
+	}
+}
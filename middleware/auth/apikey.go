@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultAPIKeyHeader = "X-API-Key"
+
+// APIKey authenticates requests carrying an API key in a header or, if QueryParam is set, in
+// the query string. Prefer the header, query parameters end up in access logs and browser history.
+type APIKey struct {
+	Realm      string
+	Header     string // defaults to X-API-Key
+	QueryParam string // empty disables keys in the query
+	Keys       *KeySet
+}
+
+func (a APIKey) Authenticate(r *http.Request) (*Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = defaultAPIKeyHeader
+	}
+	key := r.Header.Get(header)
+	if key == "" && a.QueryParam != "" {
+		key = r.URL.Query().Get(a.QueryParam)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	if a.Keys == nil {
+		return nil, ErrInvalidCredentials
+	}
+	name, ok := a.Keys.Lookup(key)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: name, Method: "apikey"}, nil
+}
+
+func (a APIKey) Challenge(error) string {
+	return fmt.Sprintf(`ApiKey realm=%q`, realmOrDefault(a.Realm))
+}
+
+// KeySet holds the valid API keys mapped to the principal name they authenticate. Keys are
+// stored hashed, and keys can be added and removed at runtime to rotate them without downtime.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys map[[sha256.Size]byte]keyEntry
+	now  func() time.Time
+}
+
+type keyEntry struct {
+	name     string
+	notAfter time.Time // zero means no expiry
+}
+
+// NewKeySet creates a KeySet from a map of key to principal name, the keys don't expire.
+func NewKeySet(keys map[string]string) *KeySet {
+	ks := &KeySet{keys: map[[sha256.Size]byte]keyEntry{}, now: time.Now}
+	for k, name := range keys {
+		ks.Add(k, name, time.Time{})
+	}
+	return ks
+}
+
+// Add registers a key, a non zero notAfter makes the key invalid after that time, which allows
+// to keep the previous key working for a grace period while clients roll over to a new one.
+func (ks *KeySet) Add(key, name string, notAfter time.Time) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[sha256.Sum256([]byte(key))] = keyEntry{name: name, notAfter: notAfter}
+}
+
+func (ks *KeySet) Remove(key string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	delete(ks.keys, sha256.Sum256([]byte(key)))
+}
+
+// Lookup returns the principal name of a valid key
+func (ks *KeySet) Lookup(key string) (string, bool) {
+	// only the hash of the key is used as map key, so the lookup time does not depend on how
+	// much of the key matches.
+	h := sha256.Sum256([]byte(key))
+	ks.mu.RLock()
+	e, ok := ks.keys[h]
+	ks.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if !e.notAfter.IsZero() && ks.now().After(e.notAfter) {
+		return "", false
+	}
+	return e.name, true
+}
@@ -0,0 +1,121 @@
+// Package auth provides composable authentication middleware: HTTP Basic, API keys, Bearer tokens and JWTs.
+// The authenticated Principal is stored in the request context and is picked up by the
+// logging middleware.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request does not carry its
+	// type of credentials, the next authenticator is then tried.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when credentials are present but not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the identity of an authenticated request
+type Principal struct {
+	Name   string
+	Method string            // authentication method, e.g. basic, apikey, bearer
+	Attrs  map[string]string // optional additional information, e.g. tenant or roles
+	Claims *Claims           // set when authenticated with a JWT
+}
+
+// Authenticator verifies the credentials of a request.
+type Authenticator interface {
+	// Authenticate returns the principal of the request, ErrNoCredentials if the request carries
+	// no credentials of this type or any other error if the credentials are not valid.
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge returns the WWW-Authenticate header value sent on a 401 response,
+	// err is the error returned by Authenticate.
+	Challenge(err error) string
+}
+
+// Middleware returns a middleware that authenticates every request with the first authenticator
+// that finds credentials in it. Requests without valid credentials are rejected with 401 and the
+// WWW-Authenticate challenges of the authenticators; the response is written with http.Error
+// so the error middleware renders it.
+func Middleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, failed, err := authenticate(r, authenticators)
+			if err != nil {
+				if failed != nil {
+					if ch := failed.Challenge(err); ch != "" {
+						w.Header().Add("WWW-Authenticate", ch)
+					}
+				} else {
+					for _, a := range authenticators {
+						if ch := a.Challenge(err); ch != "" {
+							w.Header().Add("WWW-Authenticate", ch)
+						}
+					}
+				}
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+		})
+	}
+}
+
+// authenticate returns the principal, or the authenticator that rejected the credentials and its error.
+// If no authenticator found credentials, the returned authenticator is nil and err is ErrNoCredentials.
+func authenticate(r *http.Request, authenticators []Authenticator) (*Principal, Authenticator, error) {
+	for _, a := range authenticators {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, a, err
+		}
+		if p == nil {
+			return nil, a, ErrInvalidCredentials
+		}
+		return p, nil, nil
+	}
+	return nil, nil, ErrNoCredentials
+}
+
+type principalKey struct{}
+type trackerKey struct{}
+
+// tracker lets middleware running before authentication read the principal once the
+// request has been handled, e.g. for logging.
+type tracker struct {
+	p *Principal
+}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok {
+		t.p = p
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx. On a context prepared with Track it also
+// returns the principal set further down the handler chain.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	if p, ok := ctx.Value(principalKey{}).(*Principal); ok && p != nil {
+		return p, true
+	}
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok && t.p != nil {
+		return t.p, true
+	}
+	return nil, false
+}
+
+// Track returns a copy of ctx in which the principal of an inner authentication middleware
+// becomes visible to FromContext on the returned context.
+func Track(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(trackerKey{}).(*tracker); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, trackerKey{}, &tracker{})
+}
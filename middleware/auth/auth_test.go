@@ -0,0 +1,202 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware/auth"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// principalHandler responds with the name of the authenticated principal
+func principalHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := auth.FromContext(r.Context())
+		if !ok {
+			http.Error(w, "no principal", http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s:%s", p.Method, p.Name)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	keys := auth.NewKeySet(map[string]string{"key-1": "service-a"})
+	verifier := auth.TokenVerifierFunc(func(ctx context.Context, token string) (*auth.Principal, error) {
+		if token != "good-token" {
+			return nil, errors.New("unknown token")
+		}
+		return &auth.Principal{Name: "alice"}, nil
+	})
+	h := auth.Middleware(
+		auth.Basic{Realm: "app", Verifier: auth.StaticUsers{"bob": "secret"}},
+		auth.APIKey{Realm: "app", QueryParam: "api_key", Keys: keys},
+		auth.Bearer{Realm: "app", Verifier: verifier},
+	)(principalHandler())
+
+	tcs := []struct {
+		name            string
+		setup           func(r *http.Request)
+		expectCode      int
+		expectBody      string
+		expectChallenge []string
+	}{
+		{
+			name:       "basic auth",
+			setup:      func(r *http.Request) { r.SetBasicAuth("bob", "secret") },
+			expectCode: 200,
+			expectBody: "basic:bob",
+		},
+		{
+			name:            "basic auth wrong password",
+			setup:           func(r *http.Request) { r.SetBasicAuth("bob", "wrong") },
+			expectCode:      401,
+			expectChallenge: []string{`Basic realm="app", charset="UTF-8"`},
+		},
+		{
+			name:       "api key header",
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", "key-1") },
+			expectCode: 200,
+			expectBody: "apikey:service-a",
+		},
+		{
+			name:       "api key query",
+			setup:      func(r *http.Request) { r.URL.RawQuery = "api_key=key-1" },
+			expectCode: 200,
+			expectBody: "apikey:service-a",
+		},
+		{
+			name:            "unknown api key",
+			setup:           func(r *http.Request) { r.Header.Set("X-API-Key", "key-2") },
+			expectCode:      401,
+			expectChallenge: []string{`ApiKey realm="app"`},
+		},
+		{
+			name:       "bearer token",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer good-token") },
+			expectCode: 200,
+			expectBody: "bearer:alice",
+		},
+		{
+			name:            "invalid bearer token",
+			setup:           func(r *http.Request) { r.Header.Set("Authorization", "Bearer bad-token") },
+			expectCode:      401,
+			expectChallenge: []string{`Bearer realm="app", error="invalid_token"`},
+		},
+		{
+			name:       "no credentials",
+			setup:      func(r *http.Request) {},
+			expectCode: 401,
+			expectChallenge: []string{
+				`Basic realm="app", charset="UTF-8"`,
+				`ApiKey realm="app"`,
+				`Bearer realm="app"`,
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			tc.setup(req)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected status %d, got %d", tc.expectCode, rec.Code)
+			}
+			if tc.expectBody != "" {
+				if diff := cmp.Diff(rec.Body.String(), tc.expectBody); diff != "" {
+					t.Errorf("unexpected value (-got +want)\n%s", diff)
+				}
+			}
+			if tc.expectChallenge != nil {
+				if diff := cmp.Diff(rec.Header().Values("WWW-Authenticate"), tc.expectChallenge); diff != "" {
+					t.Errorf("unexpected value (-got +want)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	content := "# comment\nbob:" + string(hash) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ht, err := auth.LoadHtpasswd(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ht.VerifyPassword("bob", "secret") {
+		t.Error("expected password to be valid")
+	}
+	if ht.VerifyPassword("bob", "wrong") {
+		t.Error("expected wrong password to be rejected")
+	}
+	if ht.VerifyPassword("alice", "secret") {
+		t.Error("expected unknown user to be rejected")
+	}
+
+	// reload picks up new users
+	content += "alice:" + string(hash) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ht.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ht.VerifyPassword("alice", "secret") {
+		t.Error("expected reloaded user to be valid")
+	}
+}
+
+func TestParseHtpasswd_RejectsNonBcrypt(t *testing.T) {
+	_, err := auth.ParseHtpasswd(strings.NewReader("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	if err == nil {
+		t.Error("expected error for non bcrypt entry")
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	ks := auth.NewKeySet(map[string]string{"old": "svc"})
+	ks.Add("new", "svc", time.Time{})
+	ks.Add("old", "svc", time.Now().Add(-time.Minute))
+
+	if _, ok := ks.Lookup("old"); ok {
+		t.Error("expected expired key to be rejected")
+	}
+	if name, ok := ks.Lookup("new"); !ok || name != "svc" {
+		t.Errorf("expected new key to be valid, got %q %v", name, ok)
+	}
+	ks.Remove("new")
+	if _, ok := ks.Lookup("new"); ok {
+		t.Error("expected removed key to be rejected")
+	}
+}
+
+func TestTrack(t *testing.T) {
+	ctx := auth.Track(context.Background())
+	if _, ok := auth.FromContext(ctx); ok {
+		t.Fatal("expected no principal")
+	}
+	_ = auth.NewContext(ctx, &auth.Principal{Name: "bob"})
+	p, ok := auth.FromContext(ctx)
+	if !ok || p.Name != "bob" {
+		t.Errorf("expected tracked principal, got %v", p)
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordVerifier checks a user and password combination
+type PasswordVerifier interface {
+	VerifyPassword(user, password string) bool
+}
+
+// Basic authenticates requests using HTTP Basic authentication
+type Basic struct {
+	Realm    string
+	Verifier PasswordVerifier
+}
+
+func (b Basic) Authenticate(r *http.Request) (*Principal, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if b.Verifier == nil || !b.Verifier.VerifyPassword(user, pass) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: user, Method: "basic"}, nil
+}
+
+func (b Basic) Challenge(error) string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realmOrDefault(b.Realm))
+}
+
+// StaticUsers is a PasswordVerifier backed by a map of user to plain text password,
+// passwords are compared in constant time.
+type StaticUsers map[string]string
+
+func (u StaticUsers) VerifyPassword(user, password string) bool {
+	expected, ok := u[user]
+	// always compare to not leak the existence of a user through timing
+	match := constantTimeEqual(expected, password)
+	return ok && match
+}
+
+// constantTimeEqual compares the hashes of both strings so that the comparison does not leak the length
+func constantTimeEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// Htpasswd is a PasswordVerifier backed by an Apache htpasswd file with bcrypt hashes,
+// as created by "htpasswd -B". Entries with other hash formats are rejected when loading.
+type Htpasswd struct {
+	path  string
+	mu    sync.RWMutex
+	users map[string][]byte
+}
+
+// dummyHash is compared against for unknown users to keep the response time constant
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return h
+})
+
+// LoadHtpasswd reads an htpasswd file, use Reload to pick up changes to the file.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ParseHtpasswd reads htpasswd entries from r.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	users, err := parseHtpasswd(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Htpasswd{users: users}, nil
+}
+
+// Reload reads the htpasswd file again, on error the previous entries are kept.
+func (h *Htpasswd) Reload() error {
+	if h.path == "" {
+		return fmt.Errorf("htpasswd was not loaded from a file")
+	}
+	f, err := os.Open(h.path) //nolint:gosec // the path is configured by the operator, not taken from requests
+	if err != nil {
+		return fmt.Errorf("opening htpasswd file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	users, err := parseHtpasswd(f)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.users = users
+	h.mu.Unlock()
+	return nil
+}
+
+func parseHtpasswd(r io.Reader) (map[string][]byte, error) {
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: malformed entry", lineNr)
+		}
+		if !strings.HasPrefix(hash, "$2y$") && !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") {
+			return nil, fmt.Errorf("htpasswd line %d: user %q does not use a bcrypt hash", lineNr, user)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading htpasswd: %w", err)
+	}
+	return users, nil
+}
+
+func (h *Htpasswd) VerifyPassword(user, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[user]
+	h.mu.RUnlock()
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+func realmOrDefault(realm string) string {
+	if realm == "" {
+		return "restricted"
+	}
+	return realm
+}
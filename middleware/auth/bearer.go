@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TokenVerifier validates a bearer token and returns the principal it belongs to
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*Principal, error)
+}
+
+// TokenVerifierFunc adapts a function to the TokenVerifier interface
+type TokenVerifierFunc func(ctx context.Context, token string) (*Principal, error)
+
+func (f TokenVerifierFunc) VerifyToken(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// Bearer authenticates requests with an "Authorization: Bearer <token>" header (RFC 6750)
+type Bearer struct {
+	Realm    string
+	Verifier TokenVerifier
+}
+
+func (b Bearer) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if b.Verifier == nil {
+		return nil, ErrInvalidCredentials
+	}
+	p, err := b.Verifier.VerifyToken(r.Context(), token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	if p != nil && p.Method == "" {
+		p.Method = "bearer"
+	}
+	return p, nil
+}
+
+func (b Bearer) Challenge(err error) string {
+	ch := fmt.Sprintf(`Bearer realm=%q`, realmOrDefault(b.Realm))
+	if errors.Is(err, ErrInvalidCredentials) {
+		ch += `, error="invalid_token"`
+	}
+	return ch
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
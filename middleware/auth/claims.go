@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Claims holds the claims of a verified JWT, the registered claims are parsed into fields and
+// all claims are available in Raw.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Scopes    []string // from the space separated scope claim or the scp array
+	Roles     []string // from the roles claim
+	Raw       map[string]any
+}
+
+func (c *Claims) UnmarshalJSON(b []byte) error {
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*c = Claims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	c.ID, _ = raw["jti"].(string)
+	c.Audience = stringOrList(raw["aud"])
+	c.ExpiresAt = numericDate(raw["exp"])
+	c.NotBefore = numericDate(raw["nbf"])
+	c.IssuedAt = numericDate(raw["iat"])
+	if s, ok := raw["scope"].(string); ok {
+		c.Scopes = strings.Fields(s)
+	} else {
+		c.Scopes = stringOrList(raw["scp"])
+	}
+	c.Roles = stringOrList(raw["roles"])
+	return nil
+}
+
+func (c *Claims) HasScope(scope string) bool { return slices.Contains(c.Scopes, scope) }
+func (c *Claims) HasRole(role string) bool   { return slices.Contains(c.Roles, role) }
+
+func stringOrList(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func numericDate(v any) time.Time {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(f), 0)
+}
+
+// ClaimsFromContext returns the JWT claims of the authenticated principal
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	p, ok := FromContext(ctx)
+	if !ok || p.Claims == nil {
+		return nil, false
+	}
+	return p.Claims, true
+}
+
+// JWT returns a middleware that authenticates requests with a bearer JWT, it is a shorthand for
+// Middleware(Bearer{Realm: realm, Verifier: v}).
+func JWT(v *JWTVerifier, realm string) func(http.Handler) http.Handler {
+	return Middleware(Bearer{Realm: realm, Verifier: v})
+}
+
+// RequireScopes returns a middleware that rejects requests whose token does not carry all the given
+// scopes with 403, requests without JWT claims are rejected with 401. Use it per route after JWT.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return requireClaims(func(c *Claims) bool {
+		for _, s := range scopes {
+			if !c.HasScope(s) {
+				return false
+			}
+		}
+		return true
+	}, fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(scopes, " ")))
+}
+
+// RequireRoles returns a middleware that only lets through requests whose token carries at least
+// one of the given roles.
+func RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return requireClaims(func(c *Claims) bool {
+		return slices.ContainsFunc(roles, c.HasRole)
+	}, `Bearer error="insufficient_scope"`)
+}
+
+func requireClaims(allowed func(c *Claims) bool, challenge string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if !allowed(c) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ParseJWKS parses a JSON Web Key Set document into StaticKeys, keys with an unsupported
+// type or curve are skipped.
+func ParseJWKS(data []byte) (StaticKeys, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing jwks: %w", err)
+	}
+	keys := StaticKeys{}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64BigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64BigInt(k.E)
+		if err != nil || e.BitLen() > 31 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64BigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64BigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func b64BigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSCfg configures a remote JWKS key provider
+type JWKSCfg struct {
+	URL    string
+	Client *http.Client // defaults to a client with a 10s timeout
+	// FetchTimeout bounds a fetch of the key set, default 10s. The fetch is not bound to the
+	// request that triggered it, so a canceled request doesn't fail the refresh for the others.
+	FetchTimeout time.Duration
+	// RefreshInterval defines how long a fetched key set is used before it is fetched again, default 1h
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits how often an unknown kid can trigger a fetch, default 1 minute
+	MinRefreshInterval time.Duration
+}
+
+// JWKS is a KeyProvider that fetches keys from a JWKS url, e.g. the jwks_uri of an OpenID provider.
+// The key set is cached and refreshed periodically in the background, a token signed with an
+// unknown kid triggers an early refresh to pick up rotated keys. Concurrent refreshes are
+// collapsed into one fetch. After failed fetches the cached keys are kept and further fetches
+// back off exponentially, up to MinRefreshInterval.
+type JWKS struct {
+	cfg         JWKSCfg
+	mu          sync.Mutex
+	keys        StaticKeys
+	fetchedAt   time.Time // last successful fetch
+	attemptedAt time.Time // start of the last fetch
+	failures    int       // consecutive failed fetches
+	lastErr     error
+	fetching    *jwksFetch
+}
+
+// jwksFetch is a fetch in progress, err is set before done is closed
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func NewJWKS(cfg JWKSCfg) *JWKS {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.FetchTimeout == 0 {
+		cfg.FetchTimeout = 10 * time.Second
+	}
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = time.Hour
+	}
+	if cfg.MinRefreshInterval == 0 {
+		cfg.MinRefreshInterval = time.Minute
+	}
+	return &JWKS{cfg: cfg}
+}
+
+func (j *JWKS) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	keys, lastErr := j.keys, j.lastErr
+	sinceAttempt := time.Since(j.attemptedAt)
+	backoff := j.backoff()
+	stale := keys == nil || time.Since(j.fetchedAt) > j.cfg.RefreshInterval
+	j.mu.Unlock()
+
+	switch {
+	case keys == nil && sinceAttempt < backoff:
+		return nil, lastErr
+	case keys == nil:
+		if err := j.refresh(ctx); err != nil {
+			return nil, err
+		}
+		keys = j.current()
+	case stale && sinceAttempt >= backoff:
+		// the cached keys are used while the refresh runs
+		j.start()
+	}
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	if j.kidRefreshAllowed() {
+		if err := j.refresh(ctx); err != nil {
+			return nil, err
+		}
+		keys = j.current()
+		if k, ok := keys[kid]; ok {
+			return k, nil
+		}
+	}
+	return keys.Key(ctx, kid, alg)
+}
+
+// kidRefreshAllowed limits the fetches triggered by unknown kids to one per MinRefreshInterval,
+// and longer while fetches fail
+func (j *JWKS) kidRefreshAllowed() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return time.Since(j.attemptedAt) > max(j.cfg.MinRefreshInterval, j.backoff())
+}
+
+func (j *JWKS) current() StaticKeys {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.keys
+}
+
+// backoff returns the minimum time between fetches after consecutive failures, j.mu needs to be held
+func (j *JWKS) backoff() time.Duration {
+	if j.failures == 0 {
+		return 0
+	}
+	return min(time.Second<<min(j.failures-1, 16), max(j.cfg.MinRefreshInterval, time.Second))
+}
+
+// start starts a fetch of the key set if none is in progress
+func (j *JWKS) start() *jwksFetch {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.fetching == nil {
+		j.fetching = &jwksFetch{done: make(chan struct{})}
+		j.attemptedAt = time.Now()
+		go j.fetch(j.fetching)
+	}
+	return j.fetching
+}
+
+// refresh waits for a fetch of the key set, starting one if none is in progress. The lock is
+// not held during the fetch, so verification with the cached keys continues meanwhile.
+func (j *JWKS) refresh(ctx context.Context) error {
+	f := j.start()
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetch downloads the key set, on failure the previous keys are kept
+func (j *JWKS) fetch(f *jwksFetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), j.cfg.FetchTimeout)
+	defer cancel()
+	keys, err := j.download(ctx)
+
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+		j.fetchedAt = time.Now()
+		j.failures = 0
+	} else {
+		j.failures++
+	}
+	j.lastErr = err
+	j.fetching = nil
+	j.mu.Unlock()
+	f.err = err
+	close(f.done)
+}
+
+func (j *JWKS) download(ctx context.Context) (StaticKeys, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.cfg.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating jwks request: %w", err)
+	}
+	resp, err := j.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading jwks: %w", err)
+	}
+	return ParseJWKS(data)
+}
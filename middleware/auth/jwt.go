@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// supported JWT signing algorithms
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+	HS256 = "HS256"
+)
+
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenNoExpiry  = errors.New("token has no exp claim")
+	ErrTokenNotValid  = errors.New("token not valid yet")
+	ErrTokenIssuer    = errors.New("invalid token issuer")
+	ErrTokenAudience  = errors.New("invalid token audience")
+	ErrUnknownKey     = errors.New("unknown signing key")
+)
+
+// KeyProvider returns the key to verify a token signature, kid and alg are taken from the
+// token header. Public keys are *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey, HS256
+// keys are []byte.
+type KeyProvider interface {
+	Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error)
+}
+
+// StaticKeys is a KeyProvider backed by a fixed map of key id to key, a token without kid is
+// verified with the key stored under the empty key id.
+type StaticKeys map[string]crypto.PublicKey
+
+func (s StaticKeys) Key(_ context.Context, kid, _ string) (crypto.PublicKey, error) {
+	k, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	return k, nil
+}
+
+// JWTVerifier validates signed JWTs (JWS compact serialization) and their registered claims.
+// It implements TokenVerifier, so it can be used with Bearer, the Principal then carries the Claims.
+type JWTVerifier struct {
+	Keys     KeyProvider
+	Issuer   string // if set, the iss claim must match
+	Audience string // if set, the aud claim must contain it
+	// Algorithms allowed in the token header, defaults to RS256, ES256 and EdDSA.
+	// HS256 needs to be enabled explicitly.
+	Algorithms []string
+	// ClockSkew is the tolerance applied to exp and nbf
+	ClockSkew time.Duration
+	// AllowNoExpiry accepts tokens without an exp claim, by default they are rejected
+	AllowNoExpiry bool
+}
+
+var defaultJWTAlgorithms = []string{RS256, ES256, EdDSA}
+
+// Verify checks the signature and the registered claims of a token and returns its claims
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	allowed := v.Algorithms
+	if len(allowed) == 0 {
+		allowed = defaultJWTAlgorithms
+	}
+	if !slices.Contains(allowed, header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q not allowed", ErrTokenSignature, header.Alg)
+	}
+	if v.Keys == nil {
+		return nil, ErrUnknownKey
+	}
+	key, err := v.Keys.Key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// VerifyToken implements TokenVerifier, the principal name is the sub claim
+func (v *JWTVerifier) VerifyToken(ctx context.Context, token string) (*Principal, error) {
+	claims, err := v.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Name: claims.Subject, Method: "jwt", Claims: claims}, nil
+}
+
+func (v *JWTVerifier) validate(c *Claims) error {
+	now := time.Now()
+	if c.ExpiresAt.IsZero() && !v.AllowNoExpiry {
+		return ErrTokenNoExpiry
+	}
+	if !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt.Add(v.ClockSkew)) {
+		return ErrTokenExpired
+	}
+	if !c.NotBefore.IsZero() && now.Before(c.NotBefore.Add(-v.ClockSkew)) {
+		return ErrTokenNotValid
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return ErrTokenIssuer
+	}
+	if v.Audience != "" && !slices.Contains(c.Audience, v.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case RS256:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match %s", ErrTokenSignature, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrTokenSignature
+		}
+	case ES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return ErrTokenSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrTokenSignature
+		}
+	case EdDSA:
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, signed, sig) {
+			return ErrTokenSignature
+		}
+	case HS256:
+		k, ok := key.([]byte)
+		if !ok || len(k) == 0 {
+			return ErrTokenSignature
+		}
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrTokenSignature
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrTokenSignature, alg)
+	}
+	return nil
+}
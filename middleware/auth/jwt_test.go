@@ -0,0 +1,358 @@
+package auth_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware/auth"
+)
+
+// signJWT creates a compact JWS for the given claims, key is the private key matching alg
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case auth.RS256:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case auth.ES256:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case auth.EdDSA:
+		sig = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	case auth.HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":   "https://idp.example.com",
+		"sub":   "alice",
+		"aud":   []string{"api"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nbf":   time.Now().Add(-time.Minute).Unix(),
+		"scope": "orders:read orders:write",
+		"roles": []string{"admin"},
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	hmacKey := []byte("hmac-secret")
+
+	v := &auth.JWTVerifier{
+		Keys: auth.StaticKeys{
+			"rsa": &rsaKey.PublicKey,
+			"ec":  &ecKey.PublicKey,
+			"ed":  edPub,
+			"hs":  hmacKey,
+		},
+		Issuer:     "https://idp.example.com",
+		Audience:   "api",
+		Algorithms: []string{auth.RS256, auth.ES256, auth.EdDSA, auth.HS256},
+		ClockSkew:  30 * time.Second,
+	}
+
+	with := func(k string, val any) map[string]any {
+		c := validClaims()
+		c[k] = val
+		return c
+	}
+	noExp := validClaims()
+	delete(noExp, "exp")
+
+	tcs := []struct {
+		name      string
+		token     string
+		expectErr error
+	}{
+		{name: "RS256", token: signJWT(t, auth.RS256, "rsa", rsaKey, validClaims())},
+		{name: "ES256", token: signJWT(t, auth.ES256, "ec", ecKey, validClaims())},
+		{name: "EdDSA", token: signJWT(t, auth.EdDSA, "ed", edKey, validClaims())},
+		{name: "HS256", token: signJWT(t, auth.HS256, "hs", hmacKey, validClaims())},
+		{
+			name:  "expired within skew",
+			token: signJWT(t, auth.RS256, "rsa", rsaKey, with("exp", time.Now().Add(-10*time.Second).Unix())),
+		},
+		{
+			name:      "expired",
+			token:     signJWT(t, auth.RS256, "rsa", rsaKey, with("exp", time.Now().Add(-time.Minute).Unix())),
+			expectErr: auth.ErrTokenExpired,
+		},
+		{
+			name:      "no expiry",
+			token:     signJWT(t, auth.RS256, "rsa", rsaKey, noExp),
+			expectErr: auth.ErrTokenNoExpiry,
+		},
+		{
+			name:      "not valid yet",
+			token:     signJWT(t, auth.RS256, "rsa", rsaKey, with("nbf", time.Now().Add(time.Hour).Unix())),
+			expectErr: auth.ErrTokenNotValid,
+		},
+		{
+			name:      "wrong issuer",
+			token:     signJWT(t, auth.RS256, "rsa", rsaKey, with("iss", "https://evil.com")),
+			expectErr: auth.ErrTokenIssuer,
+		},
+		{
+			name:      "wrong audience",
+			token:     signJWT(t, auth.RS256, "rsa", rsaKey, with("aud", "other")),
+			expectErr: auth.ErrTokenAudience,
+		},
+		{
+			name:      "key type mismatch",
+			token:     signJWT(t, auth.HS256, "rsa", hmacKey, validClaims()),
+			expectErr: auth.ErrTokenSignature,
+		},
+		{
+			name:      "unknown kid",
+			token:     signJWT(t, auth.RS256, "nope", rsaKey, validClaims()),
+			expectErr: auth.ErrUnknownKey,
+		},
+		{
+			name:      "malformed",
+			token:     "abc.def",
+			expectErr: auth.ErrTokenMalformed,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), tc.token)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.Subject != "alice" || !claims.HasScope("orders:write") || !claims.HasRole("admin") {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWTVerifier_HS256NotAllowedByDefault(t *testing.T) {
+	v := &auth.JWTVerifier{Keys: auth.StaticKeys{"": []byte("secret")}}
+	_, err := v.Verify(context.Background(), signJWT(t, auth.HS256, "", []byte("secret"), validClaims()))
+	if !errors.Is(err, auth.ErrTokenSignature) {
+		t.Errorf("expected signature error, got %v", err)
+	}
+}
+
+func TestJWTVerifier_AllowNoExpiry(t *testing.T) {
+	key := []byte("secret")
+	claims := validClaims()
+	delete(claims, "exp")
+	v := &auth.JWTVerifier{Keys: auth.StaticKeys{"": key}, Algorithms: []string{auth.HS256}, AllowNoExpiry: true}
+	if _, err := v.Verify(context.Background(), signJWT(t, auth.HS256, "", key, claims)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	key1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key2, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecJWK := func(kid string, k *ecdsa.PrivateKey) string {
+		return fmt.Sprintf(`{"kty":"EC","crv":"P-256","kid":%q,"use":"sig","x":%q,"y":%q}`,
+			kid, b64(k.X.FillBytes(make([]byte, 32))), b64(k.Y.FillBytes(make([]byte, 32))))
+	}
+
+	var fetches atomic.Int32
+	var rotated atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		keys := ecJWK("k1", key1)
+		if rotated.Load() {
+			keys += "," + ecJWK("k2", key2)
+		}
+		_, _ = fmt.Fprintf(w, `{"keys":[%s]}`, keys)
+	}))
+	defer srv.Close()
+
+	v := &auth.JWTVerifier{Keys: auth.NewJWKS(auth.JWKSCfg{URL: srv.URL, MinRefreshInterval: time.Nanosecond})}
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), signJWT(t, auth.ES256, "k1", key1, validClaims())); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected key set to be cached, got %d fetches", n)
+	}
+
+	// a token with a new kid triggers a refresh
+	rotated.Store(true)
+	if _, err := v.Verify(context.Background(), signJWT(t, auth.ES256, "k2", key2, validClaims())); err != nil {
+		t.Fatalf("unexpected error after rotation: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected a refresh for the unknown kid, got %d fetches", n)
+	}
+}
+
+func TestJWKS_Backoff(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","kid":"k1","x":%q,"y":%q}]}`,
+		b64(key.X.FillBytes(make([]byte, 32))), b64(key.Y.FillBytes(make([]byte, 32))))
+
+	var fetches atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprint(w, jwks)
+	}))
+	defer srv.Close()
+	keys := auth.NewJWKS(auth.JWKSCfg{URL: srv.URL, MinRefreshInterval: time.Nanosecond})
+
+	if _, err := keys.Key(context.Background(), "k1", auth.ES256); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	down.Store(true)
+	// the first unknown kid triggers a fetch that fails, the following ones back off
+	for i := 0; i < 5; i++ {
+		if _, err := keys.Key(context.Background(), "unknown", auth.ES256); err == nil {
+			t.Error("expected an error for the unknown kid")
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected fetches to back off after a failure, got %d fetches", n)
+	}
+	// the cached keys keep working
+	if _, err := keys.Key(context.Background(), "k1", auth.ES256); err != nil {
+		t.Errorf("expected the cached key while the provider is down, got %v", err)
+	}
+}
+
+func TestJWKS_ConcurrentRefresh(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","kid":"k1","x":%q,"y":%q}]}`,
+		b64(key.X.FillBytes(make([]byte, 32))), b64(key.Y.FillBytes(make([]byte, 32))))
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		_, _ = fmt.Fprint(w, jwks)
+	}))
+	defer srv.Close()
+	keys := auth.NewJWKS(auth.JWKSCfg{URL: srv.URL})
+
+	// a canceled request gives up waiting, but doesn't cancel the fetch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := keys.Key(ctx, "k1", auth.ES256); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := keys.Key(context.Background(), "k1", auth.ES256)
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected concurrent refreshes to be collapsed, got %d fetches", n)
+	}
+}
+
+func TestJWTMiddleware_Requirements(t *testing.T) {
+	key := []byte("secret")
+	v := &auth.JWTVerifier{Keys: auth.StaticKeys{"": key}, Algorithms: []string{auth.HS256}}
+
+	tcs := []struct {
+		name       string
+		require    func(http.Handler) http.Handler
+		token      string
+		expectCode int
+	}{
+		{
+			name:       "scope present",
+			require:    auth.RequireScopes("orders:read"),
+			token:      signJWT(t, auth.HS256, "", key, validClaims()),
+			expectCode: 200,
+		},
+		{
+			name:       "scope missing",
+			require:    auth.RequireScopes("orders:read", "users:admin"),
+			token:      signJWT(t, auth.HS256, "", key, validClaims()),
+			expectCode: 403,
+		},
+		{
+			name:       "role present",
+			require:    auth.RequireRoles("viewer", "admin"),
+			token:      signJWT(t, auth.HS256, "", key, validClaims()),
+			expectCode: 200,
+		},
+		{
+			name:       "no token",
+			require:    auth.RequireRoles("admin"),
+			expectCode: 401,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			h := auth.JWT(v, "api")(tc.require(principalHandler()))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected status %d, got %d", tc.expectCode, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-bumbu/http/lib/limitio"
+)
+
+// DefaultCaptureTypes are the content types captured if none are configured
+var DefaultCaptureTypes = []string{"application/json", "application/xml", "application/x-www-form-urlencoded", "text/"}
+
+// BodyCapture logs request bodies and successful response bodies for debugging, error response
+// bodies are always logged as err-handlerMsg. The bodies are logged as req-body and resp-body,
+// passed through LogRules.Redactor before the truncation marker is added. A body cut at MaxBytes
+// is no valid JSON, so Redaction masks it completely if JSONPaths are set.
+type BodyCapture struct {
+	// MaxBytes is the maximum captured size of each body, default 4096
+	MaxBytes int
+	// ContentTypes are the media types to capture, entries ending in "/" match the whole type,
+	// e.g. "text/". Default DefaultCaptureTypes
+	ContentTypes []string
+	// Paths limits the capture to path patterns, see LogRules; empty captures all paths
+	Paths []string
+}
+
+// capture starts capturing the bodies of the request if it matches the BodyCapture
+func (l *logRules) capture(r *http.Request, respWriter *StatWriter) {
+	if l == nil || l.CaptureBody == nil {
+		return
+	}
+	bc := l.CaptureBody
+	if matchAny(l.ExcludePaths, r.URL.Path) || (len(bc.Paths) > 0 && !matchAny(bc.Paths, r.URL.Path)) {
+		return
+	}
+	maxBytes := bc.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = 4096
+	}
+	respWriter.respBody = &limitio.LimitedBuf{MaxBytes: maxBytes}
+	if r.Body != nil && r.Body != http.NoBody && bc.matchType(r.Header.Get("Content-Type")) {
+		respWriter.reqBody = &limitio.LimitedBuf{MaxBytes: maxBytes}
+		r.Body = &teeBody{ReadCloser: r.Body, buf: respWriter.reqBody}
+	}
+}
+
+// bodyAttrs returns the captured bodies to log
+func (l *logRules) bodyAttrs(respWriter *StatWriter) []slog.Attr {
+	if l == nil || l.CaptureBody == nil {
+		return nil
+	}
+	var attrs []slog.Attr
+	if respWriter.reqBody != nil && respWriter.reqBody.Len() > 0 {
+		attrs = append(attrs, slog.String("req-body", l.capturedBody(respWriter.reqBody)))
+	}
+	if respWriter.respBody != nil && respWriter.respBody.Len() > 0 && !IsStatusError(respWriter.StatusCode()) &&
+		l.CaptureBody.matchType(respWriter.Header().Get("Content-Type")) {
+		attrs = append(attrs, slog.String("resp-body", l.capturedBody(respWriter.respBody)))
+	}
+	return attrs
+}
+
+// capturedBody redacts the body first, the truncation marker would make any body unparsable
+func (l *logRules) capturedBody(buf *limitio.LimitedBuf) string {
+	body := string(bytes.TrimRight(buf.Bytes(), "\n"))
+	if l.Redactor != nil {
+		body = l.Redactor.RedactBody(body)
+	}
+	if buf.Truncated() {
+		body += " [truncated]"
+	}
+	return body
+}
+
+func (bc *BodyCapture) matchType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := bc.ContentTypes
+	if len(types) == 0 {
+		types = DefaultCaptureTypes
+	}
+	for _, t := range types {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// teeBody copies what the handler reads from the request body into buf
+type teeBody struct {
+	io.ReadCloser
+	buf *limitio.LimitedBuf
+}
+
+func (t *teeBody) Read(b []byte) (int, error) {
+	n, err := t.ReadCloser.Read(b)
+	if n > 0 {
+		// partial content is acceptable for logging
+		_, _ = t.buf.Write(b[:n])
+	}
+	return n, err
+}
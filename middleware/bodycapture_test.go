@@ -0,0 +1,147 @@
+package middleware_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestLogging_CaptureBody(t *testing.T) {
+	echo := func(contentType string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", contentType)
+			_, _ = w.Write(body)
+		})
+	}
+	tcs := []struct {
+		name        string
+		capture     middleware.BodyCapture
+		path        string
+		reqType     string
+		respType    string
+		body        string
+		expect      []string
+		notExpected []string
+	}{
+		{
+			name:     "request and response",
+			capture:  middleware.BodyCapture{},
+			path:     "/api",
+			reqType:  "application/json",
+			respType: "application/json; charset=utf-8",
+			body:     `{"email":"jane@example.com"}`,
+			expect:   []string{`req-body="{\"email\":\"[REDACTED]\"}"`, `resp-body="{\"email\":\"[REDACTED]\"}"`},
+		},
+		{
+			name:     "truncated",
+			capture:  middleware.BodyCapture{MaxBytes: 5},
+			path:     "/api",
+			reqType:  "text/plain",
+			respType: "text/plain",
+			body:     "0123456789",
+			expect:   []string{`req-body="01234 [truncated]"`, `resp-body="01234 [truncated]"`},
+		},
+		{
+			name:        "content type filtered",
+			capture:     middleware.BodyCapture{ContentTypes: []string{"application/json"}},
+			path:        "/api",
+			reqType:     "application/octet-stream",
+			respType:    "text/plain",
+			body:        "binary",
+			notExpected: []string{"req-body", "resp-body"},
+		},
+		{
+			name:        "path filtered",
+			capture:     middleware.BodyCapture{Paths: []string{"/debug/**"}},
+			path:        "/api",
+			reqType:     "text/plain",
+			respType:    "text/plain",
+			body:        "hello",
+			notExpected: []string{"req-body", "resp-body"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&buf, nil))
+			capture := tc.capture
+			rules := middleware.LogRules{
+				CaptureBody: &capture,
+				Redactor:    middleware.Redaction{BodyPatterns: middleware.DefaultRedactPatterns},
+			}
+			h := middleware.LoggingWithRules(logger, rules)(echo(tc.respType))
+
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.reqType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			// the handler reads and the client receives the full body
+			if rec.Body.String() != tc.body {
+				t.Errorf("unexpected response body %q", rec.Body.String())
+			}
+			got := buf.String()
+			for _, expect := range tc.expect {
+				if !strings.Contains(got, expect) {
+					t.Errorf("expected %s in log line %q", expect, got)
+				}
+			}
+			for _, notExpected := range tc.notExpected {
+				if strings.Contains(got, notExpected) {
+					t.Errorf("unexpected %s in log line %q", notExpected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLogging_CaptureBodyTruncatedJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	rules := middleware.LogRules{
+		CaptureBody: &middleware.BodyCapture{MaxBytes: 32},
+		Redactor:    middleware.Redaction{JSONPaths: []string{"password"}},
+	}
+	h := middleware.LoggingWithRules(logger, rules)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	body := `{"password":"s3cr3t","name":"` + strings.Repeat("x", 100) + `"}`
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := buf.String()
+	if strings.Contains(got, "s3cr3t") {
+		t.Errorf("log line leaks the password of a truncated body: %q", got)
+	}
+	if !strings.Contains(got, `req-body="[REDACTED] [truncated]"`) {
+		t.Errorf("expected masked request body, got %q", got)
+	}
+}
+
+func TestMiddleware_CaptureBodyErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	m := middleware.New(middleware.Cfg{
+		Logger:   logger,
+		LogRules: &middleware.LogRules{CaptureBody: &middleware.BodyCapture{}},
+	})
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		http.Error(w, "bad input", http.StatusBadRequest)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	got := buf.String()
+	if strings.Contains(got, "resp-body") || !strings.Contains(got, "err-handlerMsg=\"bad input\"") {
+		t.Errorf("expected error body only in err-handlerMsg, got %q", got)
+	}
+}
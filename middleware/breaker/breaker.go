@@ -0,0 +1,353 @@
+// Package breaker implements a circuit breaker that can protect outbound calls as an
+// http.RoundTripper and inbound routes as a server middleware.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// ErrOpen is returned when the breaker rejects a call without executing it
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State of the circuit breaker
+type State int
+
+const (
+	// StateClosed lets all calls through and counts the failures
+	StateClosed State = iota
+	// StateHalfOpen lets a limited number of probe calls through to test if the dependency recovered
+	StateHalfOpen
+	// StateOpen rejects all calls until OpenTimeout elapsed
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Cfg configures a circuit breaker, the breaker trips when either of the policies is met.
+type Cfg struct {
+	// Name identifies the breaker in logs and metrics
+	Name string
+	// ConsecutiveFailures trips the breaker after this many failures in a row, default 5, -1 disables it
+	ConsecutiveFailures int
+	// FailureRatio trips the breaker when the ratio of failed calls in Window reaches it, 0 disables it
+	FailureRatio float64
+	// MinRequests is the number of calls in Window needed before FailureRatio is evaluated, default 10
+	MinRequests int
+	// Window is the interval after which the counts of the closed state are reset, default 1 minute
+	Window time.Duration
+	// OpenTimeout is how long the breaker stays open before allowing probe calls, default 30s
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probe calls allowed in half-open state, all of them need
+	// to succeed to close the breaker again, default 1
+	HalfOpenRequests int
+	// IsFailure decides if a call failed, by default transport errors and 5xx responses are failures.
+	// It is not called for calls canceled by the client, those release their slot without an outcome.
+	IsFailure func(statusCode int, err error) bool
+	// OnStateChange is called after every state transition
+	OnStateChange func(name string, from, to State)
+	// Logger logs state transitions, optional
+	Logger *slog.Logger
+	// Metrics is optional, use NewPromMetrics to create it
+	Metrics Metrics
+}
+
+// Breaker is a circuit breaker, create one per dependency or route with New.
+type Breaker struct {
+	cfg Cfg
+	now func() time.Time
+
+	mu         sync.Mutex
+	state      State
+	generation uint64    // incremented on every transition, results of older generations are ignored
+	expiry     time.Time // end of the closed window, or end of the open state
+	counts     counts
+}
+
+type counts struct {
+	requests            int
+	failures            int
+	consecutiveFailures int
+	successes           int
+}
+
+type transition struct {
+	from, to State
+}
+
+// New creates a breaker in closed state
+func New(cfg Cfg) *Breaker {
+	if cfg.ConsecutiveFailures == 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window == 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests == 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = defaultIsFailure
+	}
+	b := &Breaker{cfg: cfg, now: time.Now}
+	b.expiry = b.now().Add(cfg.Window)
+	cfg.Metrics.setState(cfg.Name, StateClosed)
+	return b
+}
+
+func defaultIsFailure(statusCode int, err error) bool {
+	return err != nil || middleware.IsServerErr(statusCode)
+}
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	state, t := b.currentState()
+	b.mu.Unlock()
+	b.notify(t)
+	return state
+}
+
+// Allow reserves a call, it returns ErrOpen if the call is rejected. Otherwise, done needs to
+// be called exactly once with the outcome of the call.
+func (b *Breaker) Allow() (done func(failure bool), err error) {
+	gen, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func(failure bool) {
+		once.Do(func() { b.done(gen, failure) })
+	}, nil
+}
+
+// allow reserves a call and returns the generation it belongs to
+func (b *Breaker) allow() (uint64, error) {
+	b.mu.Lock()
+	state, t := b.currentState()
+	if state == StateOpen || (state == StateHalfOpen && b.counts.requests >= b.cfg.HalfOpenRequests) {
+		b.mu.Unlock()
+		b.notify(t)
+		b.cfg.Metrics.incRejected(b.cfg.Name)
+		return 0, ErrOpen
+	}
+	b.counts.requests++
+	gen := b.generation
+	b.mu.Unlock()
+	b.notify(t)
+	return gen, nil
+}
+
+// retryAfter returns the time until the breaker allows probe calls again
+func (b *Breaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateOpen {
+		return 0
+	}
+	return b.expiry.Sub(b.now())
+}
+
+func (b *Breaker) done(gen uint64, failure bool) {
+	b.mu.Lock()
+	state, t := b.currentState()
+	var trip *transition
+	if gen == b.generation {
+		if failure {
+			trip = b.onFailure(state)
+		} else {
+			trip = b.onSuccess(state)
+		}
+	}
+	b.mu.Unlock()
+	b.notify(t)
+	b.notify(trip)
+}
+
+// release gives back the slot of a call without an outcome, e.g. a call canceled by the client,
+// so it neither counts towards the failure ratio nor closes a half-open breaker
+func (b *Breaker) release(gen uint64) {
+	b.mu.Lock()
+	_, t := b.currentState()
+	if gen == b.generation && b.counts.requests > 0 {
+		b.counts.requests--
+	}
+	b.mu.Unlock()
+	b.notify(t)
+}
+
+func (b *Breaker) onFailure(state State) *transition {
+	switch state {
+	case StateClosed:
+		b.counts.failures++
+		b.counts.consecutiveFailures++
+		if b.shouldTrip() {
+			return b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		return b.setState(StateOpen)
+	}
+	return nil
+}
+
+func (b *Breaker) onSuccess(state State) *transition {
+	switch state {
+	case StateClosed:
+		b.counts.consecutiveFailures = 0
+	case StateHalfOpen:
+		b.counts.successes++
+		if b.counts.successes >= b.cfg.HalfOpenRequests {
+			return b.setState(StateClosed)
+		}
+	}
+	return nil
+}
+
+func (b *Breaker) shouldTrip() bool {
+	if b.cfg.ConsecutiveFailures > 0 && b.counts.consecutiveFailures >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.cfg.FailureRatio > 0 && b.counts.requests >= b.cfg.MinRequests {
+		return float64(b.counts.failures)/float64(b.counts.requests) >= b.cfg.FailureRatio
+	}
+	return false
+}
+
+// currentState applies the time based transitions, it needs to be called with the lock held
+func (b *Breaker) currentState() (State, *transition) {
+	now := b.now()
+	var t *transition
+	switch b.state {
+	case StateClosed:
+		if now.After(b.expiry) {
+			b.counts = counts{}
+			b.expiry = now.Add(b.cfg.Window)
+		}
+	case StateOpen:
+		if now.After(b.expiry) {
+			t = b.setState(StateHalfOpen)
+		}
+	}
+	return b.state, t
+}
+
+// setState needs to be called with the lock held, the returned transition is notified after unlocking
+func (b *Breaker) setState(s State) *transition {
+	if s == b.state {
+		return nil
+	}
+	t := &transition{from: b.state, to: s}
+	b.state = s
+	b.generation++
+	b.counts = counts{}
+	switch s {
+	case StateClosed:
+		b.expiry = b.now().Add(b.cfg.Window)
+	case StateOpen:
+		b.expiry = b.now().Add(b.cfg.OpenTimeout)
+	default:
+		b.expiry = time.Time{}
+	}
+	return t
+}
+
+func (b *Breaker) notify(t *transition) {
+	if t == nil {
+		return
+	}
+	b.cfg.Metrics.setState(b.cfg.Name, t.to)
+	if b.cfg.Logger != nil {
+		level := slog.LevelInfo
+		if t.to == StateOpen {
+			level = slog.LevelWarn
+		}
+		b.cfg.Logger.Log(context.Background(), level, "circuit breaker state change",
+			slog.String("name", b.cfg.Name), slog.String("from", t.from.String()), slog.String("to", t.to.String()))
+	}
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.cfg.Name, t.from, t.to)
+	}
+}
+
+// Middleware protects a route, while the breaker is open requests fail fast with 503 Service
+// Unavailable written with http.Error, so it is rendered by the error middleware.
+func (b *Breaker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gen, err := b.allow()
+		if err != nil {
+			if d := b.retryAfter(); d > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(d.Seconds())+1))
+			}
+			http.Error(w, "service unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		sw := middleware.NewWriter(w, false, false)
+		completed := false
+		defer func() {
+			if !completed {
+				// the handler panicked
+				b.done(gen, true)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+		completed = true
+		if err := r.Context().Err(); errors.Is(err, context.Canceled) {
+			b.release(gen)
+			return
+		}
+		b.done(gen, b.cfg.IsFailure(sw.StatusCode(), r.Context().Err()))
+	})
+}
+
+// RoundTripper protects outbound calls, while the breaker is open RoundTrip returns an error
+// wrapping ErrOpen without sending the request.
+func (b *Breaker) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		gen, err := b.allow()
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Host, err)
+		}
+		resp, err := next.RoundTrip(req)
+		if errors.Is(err, context.Canceled) {
+			b.release(gen)
+			return resp, err
+		}
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		b.done(gen, b.cfg.IsFailure(statusCode, err))
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -0,0 +1,230 @@
+package breaker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.t
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.t = f.t.Add(d)
+}
+
+func newTestBreaker(cfg Cfg) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := New(cfg)
+	b.now = clock.now
+	b.expiry = clock.now().Add(b.cfg.Window)
+	return b, clock
+}
+
+func call(t *testing.T, b *Breaker, failure bool) error {
+	t.Helper()
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	done(failure)
+	return nil
+}
+
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	var transitions []string
+	b, clock := newTestBreaker(Cfg{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         10 * time.Second,
+		OnStateChange: func(name string, from, to State) {
+			transitions = append(transitions, from.String()+">"+to.String())
+		},
+	})
+
+	_ = call(t, b, true)
+	_ = call(t, b, true)
+	_ = call(t, b, false) // resets the consecutive count
+	_ = call(t, b, true)
+	_ = call(t, b, true)
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+	_ = call(t, b, true)
+	if b.State() != StateOpen {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+	if err := call(t, b, false); !errors.Is(err, ErrOpen) {
+		t.Fatalf("expected ErrOpen, got %v", err)
+	}
+
+	clock.advance(11 * time.Second)
+	done, err := b.Allow()
+	if err != nil || b.State() != StateHalfOpen {
+		t.Fatalf("expected probe in half-open, got %v %s", err, b.State())
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("expected only one probe in half-open, got %v", err)
+	}
+	done(false)
+	if b.State() != StateClosed {
+		t.Errorf("expected closed after successful probe, got %s", b.State())
+	}
+
+	want := "closed>open,open>half-open,half-open>closed"
+	if got := strings.Join(transitions, ","); got != want {
+		t.Errorf("expected transitions %q, got %q", want, got)
+	}
+}
+
+func TestBreaker_HalfOpenFailureReopens(t *testing.T) {
+	b, clock := newTestBreaker(Cfg{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	_ = call(t, b, true)
+	clock.advance(2 * time.Second)
+	_ = call(t, b, true)
+	if b.State() != StateOpen {
+		t.Errorf("expected open after failed probe, got %s", b.State())
+	}
+}
+
+func TestBreaker_HalfOpenCanceledProbe(t *testing.T) {
+	b, clock := newTestBreaker(Cfg{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	_ = call(t, b, true)
+	clock.advance(2 * time.Second)
+
+	// a client going away during the probe neither closes nor reopens the breaker
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expected half-open after canceled probe, got %s", b.State())
+	}
+	// the probe slot was released
+	if err := call(t, b, false); err != nil {
+		t.Fatalf("expected another probe to be allowed, got %v", err)
+	}
+	if b.State() != StateClosed {
+		t.Errorf("expected closed after successful probe, got %s", b.State())
+	}
+}
+
+func TestBreaker_FailureRatio(t *testing.T) {
+	b, clock := newTestBreaker(Cfg{ConsecutiveFailures: -1, FailureRatio: 0.5, MinRequests: 4, Window: time.Minute})
+
+	_ = call(t, b, true)
+	_ = call(t, b, false)
+	_ = call(t, b, true)
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed below MinRequests, got %s", b.State())
+	}
+	// the window resets the counts
+	clock.advance(2 * time.Minute)
+	_ = call(t, b, true)
+	_ = call(t, b, false)
+	_ = call(t, b, false)
+	_ = call(t, b, true)
+	if b.State() != StateOpen {
+		t.Errorf("expected open at 50%% failures, got %s", b.State())
+	}
+}
+
+func TestBreaker_IgnoresStaleResults(t *testing.T) {
+	b, _ := newTestBreaker(Cfg{ConsecutiveFailures: 1})
+	slow, _ := b.Allow()
+	_ = call(t, b, true)
+	// the result of a call started before the breaker opened does not count
+	slow(false)
+	if b.State() != StateOpen {
+		t.Errorf("expected open, got %s", b.State())
+	}
+}
+
+func TestBreaker_Middleware(t *testing.T) {
+	b, _ := newTestBreaker(Cfg{ConsecutiveFailures: 2})
+	calls := 0
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if calls != 2 {
+		t.Errorf("expected handler to be called twice, got %d", calls)
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected fast fail 503 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestBreaker_RoundTripper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	b, _ := newTestBreaker(Cfg{Name: "upstream", ConsecutiveFailures: 2, Logger: logger})
+	client := &http.Client{Transport: b.RoundTripper(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	_, err := client.Get(srv.URL)
+	if !errors.Is(err, ErrOpen) {
+		t.Errorf("expected ErrOpen, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "level=WARN") || !strings.Contains(buf.String(), "name=upstream") {
+		t.Errorf("expected state change to be logged, got %q", buf.String())
+	}
+}
+
+func TestBreaker_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewPromMetrics("", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newTestBreaker(Cfg{Name: "db", ConsecutiveFailures: 1, Metrics: m})
+	_ = call(t, b, true)
+	_ = call(t, b, false)
+
+	var metric dto.Metric
+	_ = m.state.WithLabelValues("db").Write(&metric)
+	if got := metric.GetGauge().GetValue(); got != float64(StateOpen) {
+		t.Errorf("expected state gauge %v, got %v", float64(StateOpen), got)
+	}
+	metric = dto.Metric{}
+	_ = m.rejected.WithLabelValues("db").Write(&metric)
+	if got := metric.GetCounter().GetValue(); got != 1 {
+		t.Errorf("expected 1 rejected call, got %v", got)
+	}
+}
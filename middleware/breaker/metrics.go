@@ -0,0 +1,57 @@
+package breaker
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics ensures the breaker metrics have been initialized with NewPromMetrics, the zero value
+// records nothing. The same Metrics can be shared by several breakers, they are told apart by name.
+type Metrics struct {
+	state    *prometheus.GaugeVec
+	rejected *prometheus.CounterVec
+}
+
+// NewPromMetrics registers a gauge of the breaker state (0 closed, 1 half-open, 2 open) and a
+// counter of rejected calls, both labelled by breaker name.
+func NewPromMetrics(prefix string, registry prometheus.Registerer) (Metrics, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if prefix == "" {
+		prefix = "requests"
+	}
+
+	state := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prefix,
+		Subsystem: "circuit_breaker",
+		Name:      "state",
+		Help:      "State of the circuit breaker: 0 closed, 1 half-open, 2 open",
+	}, []string{"name"})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prefix,
+		Subsystem: "circuit_breaker",
+		Name:      "rejected_total",
+		Help:      "Number of calls rejected by the circuit breaker",
+	}, []string{"name"})
+	if err := registry.Register(state); err != nil {
+		return Metrics{}, fmt.Errorf("registering prometheus gauge: %w", err)
+	}
+	if err := registry.Register(rejected); err != nil {
+		return Metrics{}, fmt.Errorf("registering prometheus counter: %w", err)
+	}
+	return Metrics{state: state, rejected: rejected}, nil
+}
+
+func (m Metrics) setState(name string, s State) {
+	if m.state != nil {
+		m.state.WithLabelValues(name).Set(float64(s))
+	}
+}
+
+func (m Metrics) incRejected(name string) {
+	if m.rejected != nil {
+		m.rejected.WithLabelValues(name).Inc()
+	}
+}
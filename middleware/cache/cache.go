@@ -0,0 +1,433 @@
+// Package cache provides an in-process HTTP response cache middleware that honours Cache-Control.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// HeaderCache is added to every cached response, the value is HIT, MISS, STALE or BYPASS
+const HeaderCache = "X-Cache"
+
+// Cfg configures the response cache
+type Cfg struct {
+	// MaxBytes bounds the total size of the stored responses, default 64MB
+	MaxBytes int64
+	// MaxEntryBytes bounds the size of a single response body, larger responses are not stored, default 1MB
+	MaxEntryBytes int
+	// Metrics is optional, use NewPromMetrics to create it
+	Metrics Metrics
+}
+
+// Cache stores GET responses in a size bounded LRU, HEAD requests are served from the
+// stored GET responses. Concurrent misses for the same key are collapsed into a single
+// handler execution.
+type Cache struct {
+	cfg Cfg
+	now func() time.Time
+
+	mu       sync.Mutex
+	lru      *list.List // of *entry, most recently used at the front
+	items    map[string]*list.Element
+	vary     map[string][]string // primary key to request headers listed in Vary
+	size     int64
+	inflight map[string]*call
+}
+
+type entry struct {
+	key        string
+	primary    string
+	statusCode int
+	header     http.Header
+	body       []byte
+	stored     time.Time
+	policy     policy
+	size       int64
+	refreshing bool
+}
+
+type call struct {
+	done chan struct{}
+}
+
+func New(cfg Cfg) *Cache {
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if cfg.MaxEntryBytes == 0 {
+		cfg.MaxEntryBytes = 1 << 20
+	}
+	return &Cache{
+		cfg:      cfg,
+		now:      time.Now,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+		vary:     map[string][]string{},
+		inflight: map[string]*call{},
+	}
+}
+
+// Middleware serves responses from the cache and stores cacheable responses of next.
+// Successful unsafe requests (POST, PUT, PATCH, DELETE) invalidate the stored responses of their URL.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			sw := middleware.NewWriter(w, false, false)
+			next.ServeHTTP(sw, r)
+			if !middleware.IsStatusError(sw.StatusCode()) {
+				c.invalidate(primaryKey(r))
+			}
+			return
+		}
+		if bypassRequest(r) {
+			c.cfg.Metrics.inc(resultBypass)
+			w.Header().Set(HeaderCache, resultBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.serve(w, r, next)
+	})
+}
+
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	primary := primaryKey(r)
+	key := c.key(primary, r)
+
+	e, state := c.lookup(key)
+	switch state {
+	case stateFresh:
+		c.cfg.Metrics.inc(resultHit)
+		c.write(w, r, e, resultHit)
+		return
+	case stateRevalidate:
+		c.cfg.Metrics.inc(resultStale)
+		c.write(w, r, e, resultStale)
+		c.refresh(e, r, next)
+		return
+	}
+
+	// collapse concurrent misses: the first request fetches, the others wait for its result
+	c.mu.Lock()
+	if cl, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+		case <-r.Context().Done():
+			return
+		}
+		if e, state := c.lookup(c.key(primary, r)); state == stateFresh {
+			c.cfg.Metrics.inc(resultHit)
+			c.write(w, r, e, resultHit)
+			return
+		}
+		c.cfg.Metrics.inc(resultMiss)
+		w.Header().Set(HeaderCache, resultMiss)
+		next.ServeHTTP(w, r)
+		return
+	}
+	cl := &call{done: make(chan struct{})}
+	c.inflight[key] = cl
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+
+	if state == stateStaleIfError {
+		// buffer the response, so the stale entry can be served instead of a server error;
+		// successful responses larger than MaxEntryBytes are streamed to the client instead
+		bw := newBufferWriter(c.cfg.MaxEntryBytes, w)
+		next.ServeHTTP(bw, r)
+		if middleware.IsServerErr(bw.statusCode) {
+			c.cfg.Metrics.inc(resultStale)
+			c.write(w, r, e, resultStale)
+			return
+		}
+		c.cfg.Metrics.inc(resultMiss)
+		if bw.streamed {
+			return
+		}
+		if r.Method == http.MethodGet {
+			c.store(primary, r, bw.statusCode, bw.header, bw.body.Bytes(), bw.overflow)
+		}
+		bw.header.Set(HeaderCache, resultMiss)
+		bw.copyTo(w)
+		return
+	}
+
+	c.cfg.Metrics.inc(resultMiss)
+	w.Header().Set(HeaderCache, resultMiss)
+	cw := middleware.NewCaptureWriter(w, c.cfg.MaxEntryBytes)
+	next.ServeHTTP(cw, r)
+	if r.Method == http.MethodGet {
+		c.store(primary, r, cw.StatusCode(), cw.CapturedHeader(), cw.Body(), cw.Truncated())
+	}
+}
+
+// refresh revalidates a stale entry in the background, only one refresh per entry runs at a time
+func (c *Cache) refresh(e *entry, r *http.Request, next http.Handler) {
+	c.mu.Lock()
+	if e.refreshing {
+		c.mu.Unlock()
+		return
+	}
+	e.refreshing = true
+	c.mu.Unlock()
+
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Method = http.MethodGet
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			e.refreshing = false
+			c.mu.Unlock()
+		}()
+		bw := newBufferWriter(c.cfg.MaxEntryBytes, nil)
+		next.ServeHTTP(bw, req)
+		if !middleware.IsServerErr(bw.statusCode) {
+			c.store(e.primary, req, bw.statusCode, bw.header, bw.body.Bytes(), bw.overflow)
+		}
+	}()
+}
+
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, e *entry, result string) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = slices.Clone(v)
+	}
+	age := int(c.now().Sub(e.stored).Seconds())
+	h.Set("Age", strconv.Itoa(max(age, 0)))
+	h.Set(HeaderCache, result)
+	w.WriteHeader(e.statusCode)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.body)
+	}
+}
+
+type lookupState int
+
+const (
+	stateMiss lookupState = iota
+	stateFresh
+	stateRevalidate   // stale, can be served while revalidating
+	stateStaleIfError // stale, can only be served if the handler fails
+)
+
+func (c *Cache) lookup(key string) (*entry, lookupState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, stateMiss
+	}
+	e := el.Value.(*entry)
+	age := c.now().Sub(e.stored)
+	switch {
+	case age < e.policy.ttl:
+		c.lru.MoveToFront(el)
+		return e, stateFresh
+	case age < e.policy.ttl+e.policy.staleWhileRevalidate:
+		c.lru.MoveToFront(el)
+		return e, stateRevalidate
+	case age < e.policy.ttl+e.policy.staleIfError:
+		return e, stateStaleIfError
+	}
+	c.remove(el)
+	c.cfg.Metrics.setSize(c.size)
+	return nil, stateMiss
+}
+
+func (c *Cache) store(primary string, r *http.Request, status int, header http.Header, body []byte, truncated bool) {
+	if truncated || len(body) > c.cfg.MaxEntryBytes {
+		return
+	}
+	now := c.now()
+	p, ok := responsePolicy(status, header, now)
+	if !ok {
+		return
+	}
+	header = header.Clone()
+	header.Del(HeaderCache)
+	e := &entry{
+		primary:    primary,
+		statusCode: status,
+		header:     header,
+		body:       bytes.Clone(body),
+		stored:     now,
+		policy:     p,
+	}
+	e.size = int64(len(e.body) + headerSize(header))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vary[primary] = varyHeaders(header)
+	e.key = c.keyLocked(primary, r)
+	if el, ok := c.items[e.key]; ok {
+		c.remove(el)
+	}
+	if e.size > c.cfg.MaxBytes {
+		c.cfg.Metrics.setSize(c.size)
+		return
+	}
+	c.items[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+	for c.size > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.cfg.Metrics.setSize(c.size)
+}
+
+func (c *Cache) invalidate(primary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		nextEl := el.Next()
+		if el.Value.(*entry).primary == primary {
+			c.remove(el)
+		}
+		el = nextEl
+	}
+	delete(c.vary, primary)
+	c.cfg.Metrics.setSize(c.size)
+}
+
+// remove needs to be called with the lock held
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.items, e.key)
+	c.size -= e.size
+}
+
+// Len returns the number of stored responses
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Purge removes all stored responses
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.items = map[string]*list.Element{}
+	c.vary = map[string][]string{}
+	c.size = 0
+	c.cfg.Metrics.setSize(0)
+}
+
+// primaryKey identifies the resource, HEAD and GET share it
+func primaryKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+func (c *Cache) key(primary string, r *http.Request) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keyLocked(primary, r)
+}
+
+// keyLocked adds the request headers listed in the Vary header of the stored response
+func (c *Cache) keyLocked(primary string, r *http.Request) string {
+	names := c.vary[primary]
+	if len(names) == 0 {
+		return primary
+	}
+	var sb strings.Builder
+	sb.WriteString(primary)
+	for _, name := range names {
+		sb.WriteString("\n" + name + ":" + strings.Join(r.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func headerSize(h http.Header) int {
+	n := 0
+	for k, vals := range h {
+		for _, v := range vals {
+			n += len(k) + len(v) + 4
+		}
+	}
+	return n
+}
+
+// bufferWriter records a response without forwarding it, the body is buffered up to maxBytes.
+// Beyond that a successful response is streamed to out if set, otherwise the rest is discarded
+// and overflow is set.
+type bufferWriter struct {
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+	maxBytes    int
+	out         http.ResponseWriter
+	overflow    bool
+	streamed    bool
+}
+
+func newBufferWriter(maxBytes int, out http.ResponseWriter) *bufferWriter {
+	return &bufferWriter{header: http.Header{}, statusCode: http.StatusOK, maxBytes: maxBytes, out: out}
+}
+
+func (b *bufferWriter) Header() http.Header { return b.header }
+
+func (b *bufferWriter) WriteHeader(code int) {
+	if !b.wroteHeader {
+		b.statusCode = code
+		b.wroteHeader = true
+	}
+}
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	if b.streamed {
+		return b.out.Write(p)
+	}
+	if b.body.Len()+len(p) <= b.maxBytes {
+		return b.body.Write(p)
+	}
+	b.overflow = true
+	if b.out == nil || middleware.IsServerErr(b.statusCode) {
+		// the response can't be stored, and a server error is replaced by the stale entry
+		return len(p), nil
+	}
+	b.streamed = true
+	b.header.Set(HeaderCache, resultMiss)
+	b.copyTo(b.out)
+	b.body.Reset()
+	return b.out.Write(p)
+}
+
+func (b *bufferWriter) copyTo(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range b.header {
+		h[k] = v
+	}
+	w.WriteHeader(b.statusCode)
+	_, _ = w.Write(b.body.Bytes())
+}
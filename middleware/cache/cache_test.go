@@ -0,0 +1,416 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeClock allows to move the cache time forward
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.t
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.t = f.t.Add(d)
+}
+
+func newTestCache(cfg Cfg) (*Cache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := New(cfg)
+	c.now = clock.now
+	return c, clock
+}
+
+// countingHandler responds with the number of times it was called
+func countingHandler(calls *atomic.Int32, cacheControl string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		_, _ = fmt.Fprintf(w, "response %d", n)
+	})
+}
+
+func get(h http.Handler, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCache_FreshAndExpired(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{})
+	h := c.Middleware(countingHandler(&calls, "max-age=60"))
+
+	if rec := get(h, "/a"); rec.Header().Get(HeaderCache) != resultMiss {
+		t.Errorf("expected miss, got %q", rec.Header().Get(HeaderCache))
+	}
+	clock.advance(30 * time.Second)
+	rec := get(h, "/a")
+	if rec.Header().Get(HeaderCache) != resultHit || rec.Body.String() != "response 1" {
+		t.Errorf("expected hit, got %q %q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if rec.Header().Get("Age") != "30" {
+		t.Errorf("expected Age 30, got %q", rec.Header().Get("Age"))
+	}
+
+	clock.advance(31 * time.Second)
+	if rec := get(h, "/a"); rec.Body.String() != "response 2" {
+		t.Errorf("expected expired entry to be fetched again, got %q", rec.Body.String())
+	}
+}
+
+func TestCache_NotCacheable(t *testing.T) {
+	tcs := []struct {
+		name         string
+		cacheControl string
+		reqHeaders   []string
+	}{
+		{name: "no freshness"},
+		{name: "no-store", cacheControl: "no-store, max-age=60"},
+		{name: "private", cacheControl: "private, max-age=60"},
+		{name: "request no-cache", cacheControl: "max-age=60", reqHeaders: []string{"Cache-Control", "no-cache"}},
+		{name: "authorization", cacheControl: "max-age=60", reqHeaders: []string{"Authorization", "Bearer x"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c, _ := newTestCache(Cfg{})
+			h := c.Middleware(countingHandler(&calls, tc.cacheControl))
+			get(h, "/a", tc.reqHeaders...)
+			get(h, "/a", tc.reqHeaders...)
+			if calls.Load() != 2 {
+				t.Errorf("expected no caching, handler called %d times", calls.Load())
+			}
+		})
+	}
+}
+
+func TestCache_SMaxAgeAndExpires(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/smax" {
+			w.Header().Set("Cache-Control", "max-age=1, s-maxage=100")
+		} else {
+			w.Header().Set("Date", clock.now().Format(http.TimeFormat))
+			w.Header().Set("Expires", clock.now().Add(100*time.Second).Format(http.TimeFormat))
+		}
+	}))
+	get(h, "/smax")
+	get(h, "/expires")
+	clock.advance(50 * time.Second)
+	get(h, "/smax")
+	get(h, "/expires")
+	if calls.Load() != 2 {
+		t.Errorf("expected s-maxage and Expires to be honoured, handler called %d times", calls.Load())
+	}
+}
+
+func TestCache_Vary(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestCache(Cfg{})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	}))
+
+	get(h, "/a", "Accept-Language", "en")
+	get(h, "/a", "Accept-Language", "de")
+	if rec := get(h, "/a", "Accept-Language", "en"); rec.Body.String() != "en" || rec.Header().Get(HeaderCache) != resultHit {
+		t.Errorf("expected cached en response, got %q", rec.Body.String())
+	}
+	if rec := get(h, "/a", "Accept-Language", "de"); rec.Body.String() != "de" || rec.Header().Get(HeaderCache) != resultHit {
+		t.Errorf("expected cached de response, got %q", rec.Body.String())
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected one call per variant, got %d", calls.Load())
+	}
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{})
+	h := c.Middleware(countingHandler(&calls, "max-age=10, stale-while-revalidate=60"))
+
+	get(h, "/a")
+	clock.advance(20 * time.Second)
+	rec := get(h, "/a")
+	if rec.Header().Get(HeaderCache) != resultStale || rec.Body.String() != "response 1" {
+		t.Errorf("expected stale response, got %q %q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+
+	// wait for the background refresh
+	for i := 0; i < 100 && calls.Load() < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if rec := get(h, "/a"); rec.Body.String() != "response 2" || rec.Header().Get(HeaderCache) != resultHit {
+		t.Errorf("expected refreshed response, got %q %q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+}
+
+func TestCache_StaleIfError(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+
+	get(h, "/a")
+	clock.advance(20 * time.Second)
+	rec := get(h, "/a")
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" || rec.Header().Get(HeaderCache) != resultStale {
+		t.Errorf("expected stale response on error, got %d %q", rec.Code, rec.Body.String())
+	}
+	clock.advance(60 * time.Second)
+	if rec := get(h, "/a"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected error after stale-if-error window, got %d", rec.Code)
+	}
+}
+
+func TestCache_CollapsesConcurrentMisses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c, _ := newTestCache(Cfg{})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get(h, "/a").Body.String()
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected a single handler execution, got %d", calls.Load())
+	}
+	for _, b := range bodies {
+		if b != "ok" {
+			t.Errorf("unexpected body %q", b)
+		}
+	}
+}
+
+func TestCache_LRUBySize(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestCache(Cfg{MaxBytes: 300})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprint(w, strings.Repeat("x", 100))
+	}))
+
+	get(h, "/a")
+	get(h, "/b")
+	get(h, "/a") // a is now the most recently used
+	get(h, "/c") // evicts b
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+	calls.Store(0)
+	get(h, "/a")
+	get(h, "/b")
+	if calls.Load() != 1 {
+		t.Errorf("expected only b to be evicted, got %d misses", calls.Load())
+	}
+}
+
+func TestCache_InvalidateOnUnsafe(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestCache(Cfg{})
+	h := c.Middleware(countingHandler(&calls, "max-age=60"))
+
+	get(h, "/a")
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/a", nil))
+	if rec := get(h, "/a"); rec.Header().Get(HeaderCache) != resultMiss {
+		t.Errorf("expected entry to be invalidated, got %q", rec.Header().Get(HeaderCache))
+	}
+}
+
+func TestCache_HeadServedFromGet(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestCache(Cfg{})
+	h := c.Middleware(countingHandler(&calls, "max-age=60"))
+
+	get(h, "/a")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("HEAD", "/a", nil))
+	if rec.Header().Get(HeaderCache) != resultHit || rec.Body.Len() != 0 {
+		t.Errorf("expected HEAD hit without body, got %q %q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+}
+
+func TestCache_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewPromMetrics("", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	c, _ := newTestCache(Cfg{Metrics: m})
+	h := c.Middleware(countingHandler(&calls, "max-age=60"))
+	get(h, "/a")
+	get(h, "/a")
+	get(h, "/a")
+
+	counter := func(result string) float64 {
+		var metric dto.Metric
+		_ = m.requests.WithLabelValues(result).Write(&metric)
+		return metric.GetCounter().GetValue()
+	}
+	if got := counter("hit"); got != 2 {
+		t.Errorf("expected 2 hits, got %v", got)
+	}
+	if got := counter("miss"); got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+}
+
+func TestCache_MetricsStaleIfError(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewPromMetrics("", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{Metrics: m})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	get(h, "/a")
+	clock.advance(20 * time.Second)
+	get(h, "/a")
+
+	counter := func(result string) float64 {
+		var metric dto.Metric
+		_ = m.requests.WithLabelValues(result).Write(&metric)
+		return metric.GetCounter().GetValue()
+	}
+	if got := counter("miss"); got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+	if got := counter("stale"); got != 1 {
+		t.Errorf("expected 1 stale, got %v", got)
+	}
+}
+
+func TestCache_StaleIfErrorHeadKeepsGet(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		if r.Method == http.MethodHead {
+			// like http.ServeContent, HEAD responses skip the body
+			return
+		}
+		_, _ = fmt.Fprint(w, "full body")
+	}))
+
+	get(h, "/a")
+	clock.advance(20 * time.Second)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/a", nil))
+	clock.advance(5 * time.Second)
+	if rec := get(h, "/a"); rec.Body.String() != "full body" {
+		t.Errorf("expected the GET response, got %q", rec.Body.String())
+	}
+}
+
+func TestCache_StaleIfErrorLargeResponse(t *testing.T) {
+	var calls atomic.Int32
+	body := strings.Repeat("x", 100)
+	c, clock := newTestCache(Cfg{MaxEntryBytes: 10})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		if calls.Add(1) == 1 {
+			_, _ = fmt.Fprint(w, "small")
+			return
+		}
+		for i := 0; i < 10; i++ {
+			_, _ = fmt.Fprint(w, body[:10])
+		}
+	}))
+
+	get(h, "/a")
+	clock.advance(20 * time.Second)
+	rec := get(h, "/a")
+	if rec.Body.String() != body || rec.Header().Get(HeaderCache) != resultMiss {
+		t.Errorf("expected the large response to be streamed, got %q %q", rec.Header().Get(HeaderCache), rec.Body.String())
+	}
+	if c.Len() != 1 {
+		t.Errorf("expected the large response not to replace the stored one, got %d entries", c.Len())
+	}
+}
+
+func TestCache_SizeAfterExpiry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewPromMetrics("", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	c, clock := newTestCache(Cfg{Metrics: m})
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Cache-Control", "max-age=10")
+		}
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	get(h, "/a")
+	clock.advance(20 * time.Second)
+	get(h, "/a")
+
+	var metric dto.Metric
+	_ = m.size.Write(&metric)
+	if got := metric.GetGauge().GetValue(); got != 0 {
+		t.Errorf("expected size 0 after the expired entry was removed, got %v", got)
+	}
+}
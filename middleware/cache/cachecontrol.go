@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directives parses a Cache-Control header into a map of lower case directive to value
+func directives(h http.Header) map[string]string {
+	d := map[string]string{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, val, _ := strings.Cut(part, "=")
+			d[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(val), `"`)
+		}
+	}
+	return d
+}
+
+func seconds(d map[string]string, name string) (time.Duration, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheableStatus lists the status codes a shared cache may store with explicit freshness
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// policy describes for how long a response can be served from the cache
+type policy struct {
+	ttl                  time.Duration // fresh lifetime
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+// responsePolicy returns the caching policy of a response, ok is false if it can't be stored.
+// Only responses with explicit freshness information (s-maxage, max-age or Expires) are stored.
+func responsePolicy(status int, h http.Header, now time.Time) (policy, bool) {
+	if !cacheableStatus[status] || h.Get("Set-Cookie") != "" {
+		return policy{}, false
+	}
+	for _, v := range h.Values("Vary") {
+		if strings.TrimSpace(v) == "*" {
+			return policy{}, false
+		}
+	}
+	d := directives(h)
+	for _, name := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := d[name]; ok {
+			return policy{}, false
+		}
+	}
+
+	var p policy
+	if ttl, ok := seconds(d, "s-maxage"); ok {
+		p.ttl = ttl
+	} else if ttl, ok := seconds(d, "max-age"); ok {
+		p.ttl = ttl
+	} else if exp := h.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			// an invalid Expires means already expired
+			return policy{}, false
+		}
+		base := now
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			base = date
+		}
+		p.ttl = t.Sub(base)
+	} else {
+		return policy{}, false
+	}
+	if _, ok := d["must-revalidate"]; !ok {
+		p.staleWhileRevalidate, _ = seconds(d, "stale-while-revalidate")
+		p.staleIfError, _ = seconds(d, "stale-if-error")
+	}
+	if p.ttl <= 0 && p.staleWhileRevalidate == 0 && p.staleIfError == 0 {
+		return policy{}, false
+	}
+	return p, true
+}
+
+// bypassRequest returns true if the request asks not to be served from the cache, or carries
+// credentials whose response must not be shared between users.
+func bypassRequest(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+	d := directives(r.Header)
+	_, noCache := d["no-cache"]
+	_, noStore := d["no-store"]
+	return noCache || noStore || r.Header.Get("Pragma") == "no-cache"
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	resultHit    = "HIT"
+	resultMiss   = "MISS"
+	resultStale  = "STALE"
+	resultBypass = "BYPASS"
+)
+
+// Metrics ensures the cache metrics have been initialized with NewPromMetrics, the zero value
+// records nothing.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	size     prometheus.Gauge
+}
+
+// NewPromMetrics registers a counter of cache lookups by result (hit, miss, stale, bypass)
+// and a gauge of the stored bytes.
+func NewPromMetrics(prefix string, registry prometheus.Registerer) (Metrics, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if prefix == "" {
+		prefix = "requests"
+	}
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prefix,
+		Subsystem: "http_cache",
+		Name:      "requests_total",
+		Help:      "Number of requests handled by the response cache by result",
+	}, []string{"result"})
+	size := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: prefix,
+		Subsystem: "http_cache",
+		Name:      "size_bytes",
+		Help:      "Size of the responses stored in the cache",
+	})
+	if err := registry.Register(requests); err != nil {
+		return Metrics{}, fmt.Errorf("registering prometheus counter: %w", err)
+	}
+	if err := registry.Register(size); err != nil {
+		return Metrics{}, fmt.Errorf("registering prometheus gauge: %w", err)
+	}
+	return Metrics{requests: requests, size: size}, nil
+}
+
+func (m Metrics) inc(result string) {
+	if m.requests != nil {
+		m.requests.WithLabelValues(strings.ToLower(result)).Inc()
+	}
+}
+
+func (m Metrics) setSize(n int64) {
+	if m.size != nil {
+		m.size.Set(float64(n))
+	}
+}
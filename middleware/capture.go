@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/go-bumbu/http/lib/limitio"
+)
+
+// CaptureWriter forwards the response to the client while keeping a copy of the status code,
+// the headers and up to maxBytes of the body, e.g. to store a response and replay it later.
+type CaptureWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	header      http.Header
+	buf         *limitio.LimitedBuf
+}
+
+func NewCaptureWriter(w http.ResponseWriter, maxBytes int) *CaptureWriter {
+	return &CaptureWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		buf: &limitio.LimitedBuf{
+			Buffer:   bytes.Buffer{},
+			MaxBytes: maxBytes,
+		},
+	}
+}
+
+func (c *CaptureWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	c.statusCode = code
+	c.header = c.ResponseWriter.Header().Clone()
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *CaptureWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	// partial content is detected with Truncated
+	_, _ = c.buf.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *CaptureWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, allowing http.ResponseController
+// to access optional interfaces (Flusher, Hijacker) on the original writer.
+func (c *CaptureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *CaptureWriter) StatusCode() int {
+	return c.statusCode
+}
+
+// CapturedHeader returns the headers as they were when the status code was written
+func (c *CaptureWriter) CapturedHeader() http.Header {
+	if c.header == nil {
+		return c.ResponseWriter.Header().Clone()
+	}
+	return c.header
+}
+
+// Body returns the captured body, check Truncated to know if it is complete
+func (c *CaptureWriter) Body() []byte {
+	return c.buf.Bytes()
+}
+
+// Truncated is true when the body exceeded the capture limit
+func (c *CaptureWriter) Truncated() bool {
+	return c.buf.Truncated()
+}
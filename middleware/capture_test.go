@@ -0,0 +1,32 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestCaptureWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cw := middleware.NewCaptureWriter(rec, 5)
+
+	cw.Header().Set("X-Test", "1")
+	cw.WriteHeader(http.StatusCreated)
+	cw.Header().Set("X-Late", "1") // set after the header was written, not captured
+	_, _ = cw.Write([]byte("Hello, World!"))
+
+	if rec.Code != http.StatusCreated || rec.Body.String() != "Hello, World!" {
+		t.Errorf("expected response to be forwarded, got %d %q", rec.Code, rec.Body.String())
+	}
+	if cw.StatusCode() != http.StatusCreated {
+		t.Errorf("expected captured status 201, got %d", cw.StatusCode())
+	}
+	if string(cw.Body()) != "Hello" || !cw.Truncated() {
+		t.Errorf("expected truncated body %q, got %q (truncated=%v)", "Hello", cw.Body(), cw.Truncated())
+	}
+	if cw.CapturedHeader().Get("X-Test") != "1" || cw.CapturedHeader().Get("X-Late") != "" {
+		t.Errorf("unexpected captured headers: %v", cw.CapturedHeader())
+	}
+}
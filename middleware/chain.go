@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Slot is a named position in the middleware chain, see Cfg.Chain
+type Slot string
+
+const (
+	SlotRequestID   Slot = "request-id"
+	SlotRealIP      Slot = "real-ip"
+	SlotRecover     Slot = "recover"
+	SlotLogging     Slot = "logging"
+	SlotMetrics     Slot = "metrics"
+	SlotTimeout     Slot = "timeout"
+	SlotCompression Slot = "compression"
+	SlotErrors      Slot = "errors"
+)
+
+// DefaultChain is a production order of all slots, the first one is the outermost
+var DefaultChain = []Slot{SlotRequestID, SlotRealIP, SlotCompression, SlotErrors, SlotLogging, SlotMetrics,
+	SlotTimeout, SlotRecover}
+
+// chainOrder lists the pairs of slots where the first needs to run before, i.e. wrap, the second
+var chainOrder = []struct {
+	before, after Slot
+	reason        string
+}{
+	{SlotRequestID, SlotLogging, "the request id needs to be set before it is logged"},
+	{SlotRealIP, SlotLogging, "the client address needs to be resolved before it is logged"},
+	{SlotCompression, SlotErrors, "the errors middleware can't read compressed error bodies"},
+	{SlotCompression, SlotLogging, "logging can't read compressed error bodies"},
+	{SlotErrors, SlotLogging, "logging needs the original error message"},
+	{SlotLogging, SlotRecover, "panics need to be recovered before the response is logged"},
+	{SlotMetrics, SlotRecover, "panics need to be recovered before the response is measured"},
+	{SlotErrors, SlotRecover, "panics need to be recovered before the error is rendered"},
+	{SlotLogging, SlotTimeout, "timeouts need to be logged"},
+	{SlotMetrics, SlotTimeout, "timeouts need to be measured"},
+}
+
+// Validate checks the Chain: known slots without duplicates, the required settings of the
+// slots, no settings that the Chain would ignore, and an order in which the slots work together.
+func (cfg Cfg) Validate() error {
+	var errs []error
+	seen := map[Slot]bool{}
+	for _, s := range cfg.Chain {
+		if seen[s] {
+			errs = append(errs, fmt.Errorf("duplicate slot %q", s))
+		}
+		seen[s] = true
+		switch s {
+		case SlotRequestID, SlotRecover, SlotLogging, SlotMetrics, SlotCompression:
+		case SlotErrors:
+			if !cfg.JsonErrors && !cfg.GenericErrs {
+				errs = append(errs, errors.New("slot errors needs JsonErrors or GenericErrs"))
+			}
+		case SlotRealIP:
+			if len(cfg.TrustedProxies) == 0 {
+				errs = append(errs, errors.New("slot real-ip needs TrustedProxies"))
+			}
+		case SlotTimeout:
+			if cfg.Timeout <= 0 {
+				errs = append(errs, errors.New("slot timeout needs a Timeout"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown slot %q", s))
+		}
+	}
+	if cfg.PanicRecover && !seen[SlotRecover] {
+		errs = append(errs, errors.New("PanicRecover needs the recover slot in the Chain"))
+	}
+	for _, o := range chainOrder {
+		before, after := slices.Index(cfg.Chain, o.before), slices.Index(cfg.Chain, o.after)
+		if before != -1 && after != -1 && before > after {
+			errs = append(errs, fmt.Errorf("slot %s needs to come before %s: %s", o.before, o.after, o.reason))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chain wraps next in the middleware of the configured slots, the first slot is the outermost
+func (c *Middleware) chain(cfg Cfg, next http.Handler) http.Handler {
+	h := next
+	for i := len(cfg.Chain) - 1; i >= 0; i-- {
+		slot := cfg.Chain[i]
+		wrapped := c.slotMiddleware(cfg, slot)(h)
+		if cfg.Skip != nil {
+			inner := h
+			wrapped = skippable(slot, cfg.Skip, wrapped, inner)
+		}
+		h = wrapped
+	}
+	return h
+}
+
+func skippable(slot Slot, skip func(*http.Request, Slot) bool, wrapped, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip(r, slot) {
+			inner.ServeHTTP(w, r)
+			return
+		}
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+func (c *Middleware) slotMiddleware(cfg Cfg, slot Slot) func(http.Handler) http.Handler {
+	switch slot {
+	case SlotRequestID:
+		return RequestID()
+	case SlotRealIP:
+		return RealIP(cfg.TrustedProxies)
+	case SlotRecover:
+		return PanicRecover(c.logger)
+	case SlotLogging:
+		return logging(c.logger, c.logRules)
+	case SlotMetrics:
+		return Metrics(c.hist)
+	case SlotTimeout:
+		return Timeout(cfg.Timeout)
+	case SlotCompression:
+		return Compress(cfg.Compress)
+	case SlotErrors:
+		if c.jsonErrors {
+			return JSONErrors(c.genericErrs)
+		}
+		if c.genericErrs {
+			return GenericErrors()
+		}
+	}
+	return func(next http.Handler) http.Handler { return next }
+}
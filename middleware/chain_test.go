@@ -0,0 +1,181 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestCfg_Validate(t *testing.T) {
+	tcs := []struct {
+		name      string
+		cfg       middleware.Cfg
+		expectErr string
+	}{
+		{
+			name: "default chain",
+			cfg: middleware.Cfg{Chain: middleware.DefaultChain, JsonErrors: true, Timeout: time.Second,
+				TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		},
+		{
+			name:      "unknown slot",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{"tracing"}},
+			expectErr: `unknown slot "tracing"`,
+		},
+		{
+			name:      "duplicate slot",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{middleware.SlotLogging, middleware.SlotLogging}},
+			expectErr: `duplicate slot "logging"`,
+		},
+		{
+			name:      "missing settings",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{middleware.SlotRealIP, middleware.SlotTimeout}},
+			expectErr: "slot real-ip needs TrustedProxies\nslot timeout needs a Timeout",
+		},
+		{
+			name:      "errors without error mode",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{middleware.SlotErrors}},
+			expectErr: "slot errors needs JsonErrors or GenericErrs",
+		},
+		{
+			name:      "panic recover without recover slot",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{middleware.SlotLogging}, PanicRecover: true},
+			expectErr: "PanicRecover needs the recover slot in the Chain",
+		},
+		{
+			name:      "recover outside logging",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{middleware.SlotRecover, middleware.SlotLogging}},
+			expectErr: "slot logging needs to come before recover",
+		},
+		{
+			name:      "compression inside errors",
+			cfg:       middleware.Cfg{Chain: []middleware.Slot{middleware.SlotErrors, middleware.SlotCompression}, JsonErrors: true},
+			expectErr: "slot compression needs to come before errors",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Errorf("expected error %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestNew_InvalidChainPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	middleware.New(middleware.Cfg{Chain: []middleware.Slot{middleware.SlotRecover, middleware.SlotLogging}})
+}
+
+func TestMiddleware_Chain(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	m := middleware.New(middleware.Cfg{
+		Chain:          middleware.DefaultChain,
+		JsonErrors:     true,
+		Logger:         logger,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Timeout:        20 * time.Millisecond,
+		Skip: func(r *http.Request, slot middleware.Slot) bool {
+			return r.URL.Path == "/healthz" && slot == middleware.SlotLogging
+		},
+	})
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/slow":
+			<-r.Context().Done()
+		case "/missing":
+			http.Error(w, "user jane not found", http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, strings.Repeat("ok", 100))
+		}
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		logs.Reset()
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("success", func(t *testing.T) {
+		rec := serve("/ok")
+		id := rec.Header().Get(middleware.HeaderRequestID)
+		if id == "" || rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Errorf("expected request id and compression, got %v", rec.Header())
+		}
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(zr); string(body) != strings.Repeat("ok", 100) {
+			t.Errorf("unexpected body %q", body)
+		}
+		for _, expect := range []string{"req-id=" + id, "ip=203.0.113.9", "response-code=200"} {
+			if !strings.Contains(logs.String(), expect) {
+				t.Errorf("expected %s in log %q", expect, logs.String())
+			}
+		}
+	})
+	t.Run("json error logged with the original message", func(t *testing.T) {
+		rec := serve("/missing")
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(zr); string(body) != `{"error":"user jane not found","code":404}` {
+			t.Errorf("unexpected body %q", body)
+		}
+		if !strings.Contains(logs.String(), `err-handlerMsg="user jane not found"`) {
+			t.Errorf("expected original error in log %q", logs.String())
+		}
+	})
+	t.Run("panic", func(t *testing.T) {
+		rec := serve("/panic")
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(logs.String(), "response-code=500") {
+			t.Errorf("expected logged 500, got %d and %q", rec.Code, logs.String())
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		rec := serve("/slow")
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(logs.String(), "response-code=503") {
+			t.Errorf("expected logged 503, got %d and %q", rec.Code, logs.String())
+		}
+	})
+	t.Run("skipped logging", func(t *testing.T) {
+		rec := serve("/healthz")
+		if rec.Code != http.StatusOK || rec.Header().Get(middleware.HeaderRequestID) == "" {
+			t.Errorf("expected the other slots to run, got %d %v", rec.Code, rec.Header())
+		}
+		if logs.Len() != 0 {
+			t.Errorf("expected no log line, got %q", logs.String())
+		}
+	})
+}
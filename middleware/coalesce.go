@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CoalesceCfg configures the request coalescing middleware
+type CoalesceCfg struct {
+	// KeyHeaders are request headers that are part of the key, requests carrying an
+	// Authorization or Cookie header are only coalesced if that header is listed here.
+	KeyHeaders []string
+	// IgnoreQuery leaves the query string out of the key
+	IgnoreQuery bool
+	// MaxBytes is the largest response body shared with waiting requests, default 1MB
+	MaxBytes int
+}
+
+// Coalesce returns a middleware that runs the handler only once for identical concurrent GET and
+// HEAD requests, the waiting requests receive a copy of the recorded response.
+// If the response grows beyond MaxBytes, the handler flushes (streaming) or sets a cookie, the
+// waiting requests are released and execute the handler independently.
+func Coalesce(cfg CoalesceCfg) func(http.Handler) http.Handler {
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	keyHeaders := make([]string, len(cfg.KeyHeaders))
+	for i, h := range cfg.KeyHeaders {
+		keyHeaders[i] = http.CanonicalHeaderKey(h)
+	}
+	g := &coalesceGroup{calls: map[string]*coalesceCall{}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := coalesceKey(r, keyHeaders, cfg.IgnoreQuery)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			c, leader := g.join(key)
+			if !leader {
+				select {
+				case <-c.done:
+				case <-r.Context().Done():
+					return
+				}
+				if !c.shared {
+					next.ServeHTTP(w, r)
+					return
+				}
+				c.replay(w)
+				return
+			}
+
+			cw := &coalesceWriter{CaptureWriter: NewCaptureWriter(w, cfg.MaxBytes), abandon: func() { g.finish(key, c, false) }}
+			shared := false
+			defer func() { g.finish(key, c, shared) }()
+			next.ServeHTTP(cw, r)
+
+			if !cw.Truncated() && !cw.private() {
+				c.statusCode = cw.StatusCode()
+				c.header = cw.CapturedHeader()
+				c.body = cw.Body()
+				shared = true
+			}
+		})
+	}
+}
+
+func coalesceKey(r *http.Request, keyHeaders []string, ignoreQuery bool) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(h) != "" && !slices.Contains(keyHeaders, h) {
+			return "", false
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(r.Method + " " + r.Host + r.URL.Path)
+	if !ignoreQuery {
+		sb.WriteString("?" + r.URL.RawQuery)
+	}
+	for _, h := range keyHeaders {
+		sb.WriteString("\n" + h + ":" + strings.Join(r.Header.Values(h), ","))
+	}
+	return sb.String(), true
+}
+
+type coalesceGroup struct {
+	mu    sync.Mutex
+	calls map[string]*coalesceCall
+}
+
+type coalesceCall struct {
+	once       sync.Once
+	done       chan struct{}
+	shared     bool
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// join returns the in-flight call for key, leader is true if the caller needs to execute it
+func (g *coalesceGroup) join(key string) (c *coalesceCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	c = &coalesceCall{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+// finish releases the waiting requests, only the first call has an effect
+func (g *coalesceGroup) finish(key string, c *coalesceCall, shared bool) {
+	c.once.Do(func() {
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		c.shared = shared
+		close(c.done)
+	})
+}
+
+func (c *coalesceCall) replay(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range c.header {
+		h[k] = slices.Clone(v)
+	}
+	w.WriteHeader(c.statusCode)
+	_, _ = w.Write(c.body)
+}
+
+// coalesceWriter releases the waiting requests as soon as the response can't be shared anymore
+type coalesceWriter struct {
+	*CaptureWriter
+	abandon func()
+}
+
+func (cw *coalesceWriter) WriteHeader(code int) {
+	cw.CaptureWriter.WriteHeader(code)
+	if cw.private() {
+		cw.abandon()
+	}
+}
+
+func (cw *coalesceWriter) Write(b []byte) (int, error) {
+	n, err := cw.CaptureWriter.Write(b)
+	if cw.Truncated() || cw.private() {
+		cw.abandon()
+	}
+	return n, err
+}
+
+// private returns true if the response sets a cookie, e.g. a session, that must not be shared
+// with other clients
+func (cw *coalesceWriter) private() bool {
+	return cw.CapturedHeader().Get("Set-Cookie") != ""
+}
+
+func (cw *coalesceWriter) Flush() {
+	cw.abandon()
+	cw.CaptureWriter.Flush()
+}
@@ -0,0 +1,183 @@
+package middleware_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// runConcurrent sends n identical requests while the handler is blocked on release
+func runConcurrent(h http.Handler, n int, release chan struct{}, mkReq func() *http.Request) []*httptest.ResponseRecorder {
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			h.ServeHTTP(rec, mkReq())
+		}(recs[i])
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return recs
+}
+
+func TestCoalesce_SharesResponse(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := middleware.Coalesce(middleware.CoalesceCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("X-Test", "1")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprint(w, "shared")
+	}))
+
+	recs := runConcurrent(h, 10, release, func() *http.Request { return httptest.NewRequest("GET", "/a?x=1", nil) })
+	if calls.Load() != 1 {
+		t.Errorf("expected a single handler execution, got %d", calls.Load())
+	}
+	for _, rec := range recs {
+		if rec.Code != http.StatusAccepted || rec.Body.String() != "shared" || rec.Header().Get("X-Test") != "1" {
+			t.Errorf("unexpected response %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+}
+
+func TestCoalesce_NotCoalesced(t *testing.T) {
+	tcs := []struct {
+		name   string
+		cfg    middleware.CoalesceCfg
+		mkReq  func(i int) *http.Request
+		expect int32
+	}{
+		{
+			name:   "post",
+			mkReq:  func(i int) *http.Request { return httptest.NewRequest("POST", "/a", nil) },
+			expect: 3,
+		},
+		{
+			name:   "different query",
+			mkReq:  func(i int) *http.Request { return httptest.NewRequest("GET", fmt.Sprintf("/a?i=%d", i), nil) },
+			expect: 3,
+		},
+		{
+			name:   "query ignored",
+			cfg:    middleware.CoalesceCfg{IgnoreQuery: true},
+			mkReq:  func(i int) *http.Request { return httptest.NewRequest("GET", fmt.Sprintf("/a?i=%d", i), nil) },
+			expect: 1,
+		},
+		{
+			name: "different key header",
+			cfg:  middleware.CoalesceCfg{KeyHeaders: []string{"accept-language"}},
+			mkReq: func(i int) *http.Request {
+				req := httptest.NewRequest("GET", "/a", nil)
+				req.Header.Set("Accept-Language", fmt.Sprint(i))
+				return req
+			},
+			expect: 3,
+		},
+		{
+			name: "authorization",
+			mkReq: func(i int) *http.Request {
+				req := httptest.NewRequest("GET", "/a", nil)
+				req.Header.Set("Authorization", "Bearer x")
+				return req
+			},
+			expect: 3,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			release := make(chan struct{})
+			h := middleware.Coalesce(tc.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				<-release
+			}))
+			var i atomic.Int32
+			runConcurrent(h, 3, release, func() *http.Request { return tc.mkReq(int(i.Add(1))) })
+			if calls.Load() != tc.expect {
+				t.Errorf("expected %d handler executions, got %d", tc.expect, calls.Load())
+			}
+		})
+	}
+}
+
+func TestCoalesce_FallbackOnLargeBody(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	body := strings.Repeat("x", 20)
+	h := middleware.Coalesce(middleware.CoalesceCfg{MaxBytes: 10})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-release
+		}
+		_, _ = fmt.Fprint(w, body)
+	}))
+
+	recs := runConcurrent(h, 3, release, func() *http.Request { return httptest.NewRequest("GET", "/a", nil) })
+	if calls.Load() != 3 {
+		t.Errorf("expected independent executions, got %d", calls.Load())
+	}
+	for _, rec := range recs {
+		if rec.Body.String() != body {
+			t.Errorf("unexpected body %q", rec.Body.String())
+		}
+	}
+}
+
+func TestCoalesce_NotSharingCookies(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := middleware.Coalesce(middleware.CoalesceCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			<-release
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: fmt.Sprintf("s%d", n)})
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+
+	recs := runConcurrent(h, 2, release, func() *http.Request { return httptest.NewRequest("GET", "/a", nil) })
+	if calls.Load() != 2 {
+		t.Errorf("expected independent executions, got %d", calls.Load())
+	}
+	if a, b := recs[0].Header().Get("Set-Cookie"), recs[1].Header().Get("Set-Cookie"); a == "" || a == b {
+		t.Errorf("expected each client to get its own cookie, got %q and %q", a, b)
+	}
+}
+
+func TestCoalesce_FallbackOnStreaming(t *testing.T) {
+	var calls atomic.Int32
+	done := make(chan struct{})
+	h := middleware.Coalesce(middleware.CoalesceCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			_, _ = fmt.Fprint(w, "event")
+			http.NewResponseController(w).Flush()
+			// the leader keeps streaming, the waiting request must not block on it
+			<-done
+			return
+		}
+		_, _ = fmt.Fprint(w, "event")
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	close(done)
+	if calls.Load() != 2 || rec.Body.String() != "event" {
+		t.Errorf("expected independent execution, got %d calls and body %q", calls.Load(), rec.Body.String())
+	}
+}
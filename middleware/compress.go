@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressTypes are the content types compressed if none are configured
+var DefaultCompressTypes = []string{"text/", "application/json", "application/javascript", "application/xml",
+	"application/x-ndjson", "image/svg+xml"}
+
+// CompressCfg configures the Compress middleware
+type CompressCfg struct {
+	// Level is the gzip compression level, default gzip.DefaultCompression
+	Level int
+	// ContentTypes are the media types to compress, entries ending in "/" match the whole type.
+	// Default DefaultCompressTypes
+	ContentTypes []string
+}
+
+// Compress returns a middleware that gzip compresses responses of the configured content types
+// for clients accepting gzip. Responses that are already encoded, range responses and HEAD
+// requests are passed through. Flushing is supported for streamed responses.
+func Compress(cfg CompressCfg) func(http.Handler) http.Handler {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressTypes
+	}
+	pool := &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+		return gz
+	}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, cfg: cfg, pool: pool, accepts: acceptsGzip(r)}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptsGzip returns true if the Accept-Encoding header allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+			name = strings.TrimSpace(name)
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	cfg     CompressCfg
+	pool    *sync.Pool
+	accepts bool
+	decided bool
+	gz      *gzip.Writer
+}
+
+// decide checks once, before the header is sent, whether the response is compressed
+func (c *compressWriter) decide(code int) {
+	if c.decided {
+		return
+	}
+	c.decided = true
+	h := c.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified ||
+		code == http.StatusPartialContent || h.Get("Content-Encoding") != "" || !c.compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if !c.accepts {
+		return
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	c.gz = c.pool.Get().(*gzip.Writer)
+	c.gz.Reset(c.ResponseWriter)
+}
+
+func (c *compressWriter) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.cfg.ContentTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	// informational responses, e.g. 103 Early Hints, precede the final one that is compressed
+	if code >= 200 {
+		c.decide(code)
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.decided {
+		if c.Header().Get("Content-Type") == "" {
+			// net/http would sniff the type after the encoding is decided, so sniff it here
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.decide(http.StatusOK)
+	}
+	if c.gz != nil {
+		return c.gz.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush sends the compressed data written so far, e.g. for server-sent events
+func (c *compressWriter) Flush() {
+	if c.gz != nil {
+		_ = c.gz.Flush()
+	}
+	_ = http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer, e.g. to hijack
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressWriter) close() {
+	if c.gz == nil {
+		return
+	}
+	_ = c.gz.Close()
+	c.gz.Reset(io.Discard)
+	c.pool.Put(c.gz)
+	c.gz = nil
+}
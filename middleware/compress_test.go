@@ -0,0 +1,132 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"hello":"world"}`, 100)
+	tcs := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		contentType    string
+		encoding       string
+		expectGzip     bool
+		expectVary     bool
+	}{
+		{name: "json", acceptEncoding: "gzip, deflate", contentType: "application/json", expectGzip: true, expectVary: true},
+		{name: "sniffed text", acceptEncoding: "gzip", expectGzip: true, expectVary: true},
+		{name: "not accepted", acceptEncoding: "br", contentType: "application/json", expectVary: true},
+		{name: "refused with q=0", acceptEncoding: "gzip;q=0", contentType: "application/json", expectVary: true},
+		{name: "binary", acceptEncoding: "gzip", contentType: "image/png"},
+		{name: "already encoded", acceptEncoding: "gzip", contentType: "text/plain", encoding: "br"},
+		{name: "head", method: "HEAD", acceptEncoding: "gzip", contentType: "text/plain"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			h := middleware.Compress(middleware.CompressCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				if tc.encoding != "" {
+					w.Header().Set("Content-Encoding", tc.encoding)
+				}
+				w.Header().Set("Content-Length", "1700")
+				_, _ = io.WriteString(w, body)
+			}))
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tc.expectGzip {
+				t.Fatalf("expected gzip %v, got encoding %q", tc.expectGzip, rec.Header().Get("Content-Encoding"))
+			}
+			if gotVary := rec.Header().Get("Vary") == "Accept-Encoding"; gotVary != tc.expectVary {
+				t.Errorf("expected vary %v, got %q", tc.expectVary, rec.Header().Get("Vary"))
+			}
+			if !tc.expectGzip || method == "HEAD" {
+				return
+			}
+			if rec.Header().Get("Content-Length") != "" {
+				t.Error("expected Content-Length to be removed")
+			}
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(zr)
+			if string(got) != body {
+				t.Errorf("unexpected decompressed body %q", got)
+			}
+		})
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	srv := httptest.NewServer(middleware.Compress(middleware.CompressCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(zr, buf); err != nil || string(buf) != "data: 1\n\n" {
+		t.Errorf("expected flushed event, got %q: %v", buf, err)
+	}
+}
+
+func TestCompress_EarlyHints(t *testing.T) {
+	h := middleware.Compress(middleware.CompressCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Repeat("hello ", 100))
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoded 200 after early hints, got %d %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gz); string(b) != strings.Repeat("hello ", 100) {
+		t.Errorf("unexpected body %q", b)
+	}
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConditionalCfg configures the conditional request middleware
+type ConditionalCfg struct {
+	// WeakETag generates weak (W/"...") instead of strong ETags
+	WeakETag bool
+	// MaxBytes is the largest response body that is buffered to compute an ETag, larger
+	// responses are sent without one, default 1MB
+	MaxBytes int
+}
+
+// Conditional returns a middleware that answers conditional GET and HEAD requests of dynamic
+// handlers with 304 Not Modified or 412 Precondition Failed.
+//
+// If the handler sets an ETag or Last-Modified header before writing the status, those validators
+// are used as they are and the body is not buffered. Otherwise, the body of 200 responses is
+// buffered up to MaxBytes and an ETag is computed from its hash.
+// Handlers can avoid rendering the body altogether by calling CheckConditional first.
+func Conditional(cfg ConditionalCfg) func(http.Handler) http.Handler {
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &conditionalWriter{ResponseWriter: w, r: r, cfg: cfg}
+			next.ServeHTTP(cw, r)
+			cw.finish()
+		})
+	}
+}
+
+// CheckConditional sets the ETag and Last-Modified response headers and evaluates the
+// request preconditions against them. If the request can be answered with 304 or 412,
+// the response is written and true is returned; the handler must not write anything else.
+// An empty etag or zero lastModified are ignored.
+func CheckConditional(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	return writePrecondition(w, r, evalPreconditions(r, w.Header()))
+}
+
+// evalPreconditions follows the evaluation order of RFC 9110 section 13.2.2 and returns
+// http.StatusNotModified, http.StatusPreconditionFailed or 0 if the request should proceed.
+func evalPreconditions(r *http.Request, h http.Header) int {
+	etag := h.Get("ETag")
+	lastModified, lmErr := http.ParseTime(h.Get("Last-Modified"))
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && lmErr == nil {
+		if lastModified.After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && lmErr == nil && safe {
+		if !lastModified.After(ims) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatch reports whether etag is listed in the header value, weak comparison ignores the W/ prefix
+func etagMatch(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writePrecondition writes the 304 or 412 response, it returns false if status is 0
+func writePrecondition(w http.ResponseWriter, r *http.Request, status int) bool {
+	switch status {
+	case http.StatusNotModified:
+		h := w.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	case http.StatusPreconditionFailed:
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return true
+	}
+	return false
+}
+
+type conditionalMode int
+
+const (
+	modePending     conditionalMode = iota // nothing written yet
+	modePassthrough                        // forwarding to the client
+	modeBuffering                          // buffering the body to compute an ETag
+	modeDiscard                            // a 304 or 412 was written, the body is dropped
+)
+
+// conditionalWriter defers the response of successful requests until validators are known
+type conditionalWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	cfg    ConditionalCfg
+	mode   conditionalMode
+	status int
+	buf    bytes.Buffer
+}
+
+func (c *conditionalWriter) WriteHeader(code int) {
+	if c.mode != modePending {
+		return
+	}
+	h := c.ResponseWriter.Header()
+	switch {
+	case code != http.StatusOK:
+		c.mode = modePassthrough
+	case h.Get("ETag") != "" || h.Get("Last-Modified") != "":
+		if writePrecondition(c.ResponseWriter, c.r, evalPreconditions(c.r, h)) {
+			c.mode = modeDiscard
+			return
+		}
+		c.mode = modePassthrough
+	default:
+		c.mode = modeBuffering
+		c.status = code
+		return
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *conditionalWriter) Write(b []byte) (int, error) {
+	if c.mode == modePending {
+		c.WriteHeader(http.StatusOK)
+	}
+	switch c.mode {
+	case modeDiscard:
+		return len(b), nil
+	case modeBuffering:
+		if c.buf.Len()+len(b) <= c.cfg.MaxBytes {
+			return c.buf.Write(b)
+		}
+		// too large to hash, send what we have and stream the rest
+		if err := c.stopBuffering(); err != nil {
+			return 0, err
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered response, streamed responses don't get an ETag
+func (c *conditionalWriter) Flush() {
+	if c.mode == modePending {
+		c.WriteHeader(http.StatusOK)
+	}
+	switch c.mode {
+	case modeDiscard:
+		return
+	case modeBuffering:
+		_ = c.stopBuffering()
+	}
+	_ = http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+func (c *conditionalWriter) stopBuffering() error {
+	c.mode = modePassthrough
+	c.ResponseWriter.WriteHeader(c.status)
+	_, err := c.ResponseWriter.Write(c.buf.Bytes())
+	c.buf.Reset()
+	return err
+}
+
+// finish computes the ETag of the buffered body and writes the final response
+func (c *conditionalWriter) finish() {
+	if c.mode == modePending {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.mode != modeBuffering {
+		return
+	}
+	sum := sha256.Sum256(c.buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if c.cfg.WeakETag {
+		etag = "W/" + etag
+	}
+	c.ResponseWriter.Header().Set("ETag", etag)
+	if writePrecondition(c.ResponseWriter, c.r, evalPreconditions(c.r, c.ResponseWriter.Header())) {
+		return
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	_, _ = c.ResponseWriter.Write(c.buf.Bytes())
+}
+
+// Unwrap returns the underlying ResponseWriter, allowing http.ResponseController
+// to access optional interfaces (Flusher, Hijacker) on the original writer.
+func (c *conditionalWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
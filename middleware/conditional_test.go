@@ -0,0 +1,171 @@
+package middleware_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestConditional_ETag(t *testing.T) {
+	h := middleware.Conditional(middleware.ConditionalCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprint(w, "hello")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("unexpected first response %d %q etag=%q", rec.Code, rec.Body.String(), etag)
+	}
+
+	tcs := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		want   int
+	}{
+		{name: "if-none-match match", method: "GET", header: "If-None-Match", value: etag, want: http.StatusNotModified},
+		{name: "if-none-match weak match", method: "HEAD", header: "If-None-Match", value: `"x", W/` + etag, want: http.StatusNotModified},
+		{name: "if-none-match mismatch", method: "GET", header: "If-None-Match", value: `"other"`, want: http.StatusOK},
+		{name: "if-match match", method: "GET", header: "If-Match", value: etag, want: http.StatusOK},
+		{name: "if-match mismatch", method: "GET", header: "If-Match", value: `"other"`, want: http.StatusPreconditionFailed},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/", nil)
+			req.Header.Set(tc.header, tc.value)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rec.Code)
+			}
+			if tc.want == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "") {
+				t.Errorf("expected empty 304, got body %q content-type %q", rec.Body.String(), rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestConditional_WeakETag(t *testing.T) {
+	h := middleware.Conditional(middleware.ConditionalCfg{WeakETag: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "hello")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	etag := rec.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected weak etag, got %q", etag)
+	}
+
+	// weak validators never satisfy If-Match
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412, got %d", rec.Code)
+	}
+}
+
+func TestConditional_HandlerValidators(t *testing.T) {
+	modified := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rendered := false
+	h := middleware.Conditional(middleware.ConditionalCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		rendered = true
+		_, _ = fmt.Fprint(w, "hello")
+	}))
+
+	tcs := []struct {
+		name   string
+		header string
+		value  time.Time
+		want   int
+	}{
+		{name: "not modified since", header: "If-Modified-Since", value: modified, want: http.StatusNotModified},
+		{name: "modified since", header: "If-Modified-Since", value: modified.Add(-time.Hour), want: http.StatusOK},
+		{name: "unmodified since", header: "If-Unmodified-Since", value: modified, want: http.StatusOK},
+		{name: "modified after", header: "If-Unmodified-Since", value: modified.Add(-time.Hour), want: http.StatusPreconditionFailed},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(tc.header, tc.value.Format(http.TimeFormat))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rec.Code)
+			}
+			if rec.Header().Get("ETag") != "" {
+				t.Errorf("expected no computed etag when the handler supplies validators")
+			}
+			if tc.want != http.StatusOK && strings.Contains(rec.Body.String(), "hello") {
+				t.Errorf("expected body to be dropped, got %q", rec.Body.String())
+			}
+		})
+	}
+	if !rendered {
+		t.Error("expected handler to run")
+	}
+}
+
+func TestConditional_LargeBody(t *testing.T) {
+	body := strings.Repeat("x", 20)
+	h := middleware.Conditional(middleware.ConditionalCfg{MaxBytes: 10})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, body[:8])
+		_, _ = fmt.Fprint(w, body[8:])
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != body || rec.Header().Get("ETag") != "" {
+		t.Errorf("expected full body without etag, got %q etag=%q", rec.Body.String(), rec.Header().Get("ETag"))
+	}
+}
+
+func TestConditional_ErrorsPassThrough(t *testing.T) {
+	h := middleware.Conditional(middleware.ConditionalCfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", "*")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("ETag") != "" {
+		t.Errorf("expected untouched 404, got %d etag=%q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestCheckConditional(t *testing.T) {
+	rendered := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.CheckConditional(w, r, `"v1"`, time.Time{}) {
+			return
+		}
+		rendered = true
+		_, _ = fmt.Fprint(w, "expensive")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rendered {
+		t.Errorf("expected 304 without rendering, got %d rendered=%v", rec.Code, rendered)
+	}
+
+	req = httptest.NewRequest("PUT", "/", nil)
+	req.Header.Set("If-Match", `"v0"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed || rendered {
+		t.Errorf("expected 412 on stale update, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// common CSP source expressions
+const (
+	CSPSelf          = "'self'"
+	CSPNone          = "'none'"
+	CSPUnsafeInline  = "'unsafe-inline'"
+	CSPUnsafeEval    = "'unsafe-eval'"
+	CSPStrictDynamic = "'strict-dynamic'"
+)
+
+// CSP is a typed builder for a Content-Security-Policy header value.
+// Directives are rendered in the order they were first added; adding sources to an
+// existing directive appends to it.
+type CSP struct {
+	names   []string
+	sources map[string][]string
+}
+
+func NewCSP() *CSP {
+	return &CSP{sources: map[string][]string{}}
+}
+
+// Add appends sources to a directive, a directive without sources (e.g. upgrade-insecure-requests)
+// is rendered only by its name.
+func (c *CSP) Add(directive string, sources ...string) *CSP {
+	if _, ok := c.sources[directive]; !ok {
+		c.names = append(c.names, directive)
+		c.sources[directive] = nil
+	}
+	c.sources[directive] = append(c.sources[directive], sources...)
+	return c
+}
+
+func (c *CSP) DefaultSrc(s ...string) *CSP     { return c.Add("default-src", s...) }
+func (c *CSP) ScriptSrc(s ...string) *CSP      { return c.Add("script-src", s...) }
+func (c *CSP) StyleSrc(s ...string) *CSP       { return c.Add("style-src", s...) }
+func (c *CSP) ImgSrc(s ...string) *CSP         { return c.Add("img-src", s...) }
+func (c *CSP) FontSrc(s ...string) *CSP        { return c.Add("font-src", s...) }
+func (c *CSP) ConnectSrc(s ...string) *CSP     { return c.Add("connect-src", s...) }
+func (c *CSP) MediaSrc(s ...string) *CSP       { return c.Add("media-src", s...) }
+func (c *CSP) ObjectSrc(s ...string) *CSP      { return c.Add("object-src", s...) }
+func (c *CSP) FrameSrc(s ...string) *CSP       { return c.Add("frame-src", s...) }
+func (c *CSP) WorkerSrc(s ...string) *CSP      { return c.Add("worker-src", s...) }
+func (c *CSP) ManifestSrc(s ...string) *CSP    { return c.Add("manifest-src", s...) }
+func (c *CSP) BaseURI(s ...string) *CSP        { return c.Add("base-uri", s...) }
+func (c *CSP) FormAction(s ...string) *CSP     { return c.Add("form-action", s...) }
+func (c *CSP) FrameAncestors(s ...string) *CSP { return c.Add("frame-ancestors", s...) }
+func (c *CSP) UpgradeInsecureRequests() *CSP   { return c.Add("upgrade-insecure-requests") }
+
+// ReportURI sets the legacy report-uri directive, use it together with CSPReportHandler.
+func (c *CSP) ReportURI(uri string) *CSP { return c.Add("report-uri", uri) }
+
+// ReportTo sets the report-to directive, the group needs to be declared in a Reporting-Endpoints header.
+func (c *CSP) ReportTo(group string) *CSP { return c.Add("report-to", group) }
+
+func (c *CSP) String() string {
+	if c == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(c.names))
+	for _, name := range c.names {
+		if src := c.sources[name]; len(src) > 0 {
+			parts = append(parts, name+" "+strings.Join(src, " "))
+		} else {
+			parts = append(parts, name)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// maxCSPReportBytes limits the size of a violation report accepted by CSPReportHandler
+const maxCSPReportBytes = 64 << 10
+
+// CSPReport holds the relevant fields of a CSP violation report, both the legacy
+// application/csp-report format and the Reporting API format are mapped into it.
+type CSPReport struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+}
+
+// reportingAPIBody is the camelCase body used by the Reporting API (application/reports+json)
+type reportingAPIBody struct {
+	DocumentURL        string `json:"documentURL"`
+	Referrer           string `json:"referrer"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+}
+
+// CSPReportHandler returns a handler that receives CSP violation reports sent by browsers
+// and logs every violation at WARN level to the given logger.
+func CSPReportHandler(logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportBytes+1))
+		if err != nil {
+			http.Error(w, "unable to read report", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxCSPReportBytes {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		reports, err := parseCSPReports(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			http.Error(w, "malformed csp report", http.StatusBadRequest)
+			return
+		}
+		if logger != nil {
+			for _, rep := range reports {
+				logger.LogAttrs(r.Context(), slog.LevelWarn, "csp violation",
+					slog.String("document-uri", rep.DocumentURI),
+					slog.String("blocked-uri", rep.BlockedURI),
+					slog.String("violated-directive", rep.ViolatedDirective),
+					slog.String("effective-directive", rep.EffectiveDirective),
+					slog.String("disposition", rep.Disposition),
+					slog.String("source-file", rep.SourceFile),
+					slog.Int("line-number", rep.LineNumber),
+					slog.String("ip", userIp(r)),
+				)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func parseCSPReports(contentType string, body []byte) ([]CSPReport, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/reports+json" {
+		var items []struct {
+			Type string           `json:"type"`
+			Body reportingAPIBody `json:"body"`
+		}
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, err
+		}
+		reports := make([]CSPReport, 0, len(items))
+		for _, item := range items {
+			if item.Type != "csp-violation" {
+				continue
+			}
+			reports = append(reports, CSPReport{
+				DocumentURI:        item.Body.DocumentURL,
+				Referrer:           item.Body.Referrer,
+				BlockedURI:         item.Body.BlockedURL,
+				ViolatedDirective:  item.Body.EffectiveDirective,
+				EffectiveDirective: item.Body.EffectiveDirective,
+				OriginalPolicy:     item.Body.OriginalPolicy,
+				Disposition:        item.Body.Disposition,
+				SourceFile:         item.Body.SourceFile,
+				LineNumber:         item.Body.LineNumber,
+			})
+		}
+		return reports, nil
+	}
+
+	// legacy format: application/csp-report, some browsers send application/json
+	var legacy struct {
+		Report CSPReport `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	return []CSPReport{legacy.Report}, nil
+}
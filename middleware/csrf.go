@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit stores a random token in a cookie readable by the SPA, unsafe requests
+	// need to send the same value in a header or form field. If a Key is configured the cookie
+	// value is signed, and with a SessionID function the signature is bound to the session, so
+	// that a cookie planted by a sibling subdomain is rejected. A session change, e.g. on login,
+	// invalidates the token and a new one is issued on the next safe request.
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer derives the token from the session id with an HMAC, the token is only
+	// available to the SPA through CSRFToken or CSRFTokenHandler.
+	CSRFSynchronizer
+)
+
+const (
+	defaultCSRFCookie = "csrf_token"
+	defaultCSRFHeader = "X-CSRF-Token"
+	defaultCSRFField  = "csrf_token"
+	csrfTokenBytes    = 32
+)
+
+// CSRFCfg configures the CSRF middleware, the zero value uses the double submit pattern with
+// secure defaults.
+type CSRFCfg struct {
+	Mode CSRFMode
+	Key  []byte // HMAC key, required by CSRFSynchronizer, signs the cookie in CSRFDoubleSubmit
+	// SessionID is required by CSRFSynchronizer, in CSRFDoubleSubmit it binds the signed cookie to the session
+	SessionID  func(r *http.Request) string
+	CookieName string // defaults to csrf_token
+	CookiePath string // defaults to /
+	// InsecureCookie drops the Secure attribute from the token cookie, only use it for local development
+	InsecureCookie bool
+	SameSite       http.SameSite // defaults to Strict
+	HeaderName     string        // defaults to X-CSRF-Token
+	FormField      string        // defaults to csrf_token
+	// TrustedOrigins lists additional origins (scheme://host[:port]) allowed to send unsafe requests
+	TrustedOrigins []string
+}
+
+type csrfCtxKey struct{}
+
+// CSRFToken returns the token the client needs to send on unsafe requests,
+// it is only available in handlers wrapped by the CSRF middleware.
+func CSRFToken(r *http.Request) string {
+	tok, _ := r.Context().Value(csrfCtxKey{}).(string)
+	return tok
+}
+
+// CSRFTokenHandler returns a handler that responds with the current token as JSON: {"token":"..."}
+// so that an SPA can fetch it on startup; it needs to be wrapped by the CSRF middleware.
+func CSRFTokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": CSRFToken(r)})
+	})
+}
+
+// CSRF returns a middleware protecting cookie authenticated endpoints against cross-site request forgery.
+// Unsafe requests (anything other than GET, HEAD, OPTIONS and TRACE) are rejected with 403 when:
+//   - the browser reports a cross-site request via the Sec-Fetch-Site header
+//   - the Origin (or Referer as fallback) does not match the request host or a trusted origin
+//   - the token sent in the header or form field does not match the expected one
+//
+// The 403 is written with http.Error, so JSONErrors or Middleware will render it as any other error.
+// CSRF panics if CSRFSynchronizer is used without a Key or a SessionID function.
+func CSRF(cfg CSRFCfg) func(http.Handler) http.Handler {
+	if cfg.CookieName == "" {
+		cfg.CookieName = defaultCSRFCookie
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+	if cfg.SameSite == 0 {
+		cfg.SameSite = http.SameSiteStrictMode
+	}
+	if cfg.HeaderName == "" {
+		cfg.HeaderName = defaultCSRFHeader
+	}
+	if cfg.FormField == "" {
+		cfg.FormField = defaultCSRFField
+	}
+	if cfg.Mode == CSRFSynchronizer && (len(cfg.Key) == 0 || cfg.SessionID == nil) {
+		panic("csrf: synchronizer mode requires a Key and a SessionID function")
+	}
+	trusted := map[string]bool{}
+	for _, o := range cfg.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			expected := cfg.expectedToken(w, r)
+
+			if !isSafeMethod(r.Method) {
+				if reason := checkCSRFOrigin(r, trusted); reason != "" {
+					http.Error(w, reason, http.StatusForbidden)
+					return
+				}
+				if expected == "" || !tokensEqual(cfg.submittedToken(r), expected) {
+					http.Error(w, "invalid csrf token", http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), csrfCtxKey{}, expected)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// expectedToken returns the token for the current request, in double submit mode
+// a new cookie is issued when it is missing or invalid.
+func (cfg CSRFCfg) expectedToken(w http.ResponseWriter, r *http.Request) string {
+	if cfg.Mode == CSRFSynchronizer {
+		sid := cfg.SessionID(r)
+		if sid == "" {
+			return ""
+		}
+		return cfg.sign(sid)
+	}
+
+	if c, err := r.Cookie(cfg.CookieName); err == nil && cfg.validCookieToken(r, c.Value) {
+		return c.Value
+	}
+	if !isSafeMethod(r.Method) {
+		// don't issue a token on a request that will be rejected anyway
+		return ""
+	}
+	tok := cfg.newCookieToken(r)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.CookieName,
+		Value:    tok,
+		Path:     cfg.CookiePath,
+		Secure:   !cfg.InsecureCookie,
+		HttpOnly: false, // the SPA needs to read it
+		SameSite: cfg.SameSite,
+	})
+	return tok
+}
+
+func (cfg CSRFCfg) newCookieToken(r *http.Request) string {
+	b := make([]byte, csrfTokenBytes)
+	_, _ = rand.Read(b)
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	if len(cfg.Key) > 0 {
+		tok += "." + cfg.sign(cfg.cookieSignInput(r, tok))
+	}
+	return tok
+}
+
+func (cfg CSRFCfg) validCookieToken(r *http.Request, tok string) bool {
+	if tok == "" {
+		return false
+	}
+	if len(cfg.Key) == 0 {
+		return true
+	}
+	val, sig, ok := strings.Cut(tok, ".")
+	return ok && tokensEqual(sig, cfg.sign(cfg.cookieSignInput(r, val)))
+}
+
+// cookieSignInput binds the signature of the cookie value to the session if there is one, so a
+// valid token obtained by an attacker can't be planted into the cookie of another user
+func (cfg CSRFCfg) cookieSignInput(r *http.Request, val string) string {
+	if cfg.SessionID == nil {
+		return val
+	}
+	return cfg.SessionID(r) + "." + val
+}
+
+func (cfg CSRFCfg) sign(val string) string {
+	mac := hmac.New(sha256.New, cfg.Key)
+	_, _ = mac.Write([]byte(val))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (cfg CSRFCfg) submittedToken(r *http.Request) string {
+	if tok := r.Header.Get(cfg.HeaderName); tok != "" {
+		return tok
+	}
+	ct := r.Header.Get("Content-Type")
+	if strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+		return r.PostFormValue(cfg.FormField)
+	}
+	return ""
+}
+
+// checkCSRFOrigin verifies Fetch Metadata and Origin headers, it returns the reason for rejection or empty string
+func checkCSRFOrigin(r *http.Request, trusted map[string]bool) string {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		if ref := r.Header.Get("Referer"); ref != "" {
+			if u, err := url.Parse(ref); err == nil {
+				origin = u.Scheme + "://" + u.Host
+			}
+		}
+	}
+	originOk := origin == "" || trusted[strings.ToLower(origin)] || sameHost(origin, r.Host)
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default: // same-site, cross-site
+		if origin == "" || !trusted[strings.ToLower(origin)] {
+			return "cross-site request rejected"
+		}
+	}
+	if !originOk {
+		return "origin not allowed"
+	}
+	return ""
+}
+
+func sameHost(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+func tokensEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
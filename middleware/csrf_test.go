@@ -0,0 +1,235 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// csrfTokenCookie performs a GET request and returns the issued cookie
+func csrfTokenCookie(t *testing.T, h http.Handler) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/", nil))
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "csrf_token" {
+			return c
+		}
+	}
+	t.Fatal("expected csrf cookie to be set")
+	return nil
+}
+
+func TestCSRF_DoubleSubmit(t *testing.T) {
+	h := middleware.CSRF(middleware.CSRFCfg{Key: []byte("secret")})(testHandler(200, "ok"))
+	cookie := csrfTokenCookie(t, h)
+
+	if !cookie.Secure || cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("unexpected cookie attributes: %+v", cookie)
+	}
+
+	tcs := []struct {
+		name       string
+		setup      func(r *http.Request)
+		expectCode int
+	}{
+		{
+			name: "valid header token",
+			setup: func(r *http.Request) {
+				r.AddCookie(cookie)
+				r.Header.Set("X-CSRF-Token", cookie.Value)
+			},
+			expectCode: 200,
+		},
+		{
+			name: "valid form token",
+			setup: func(r *http.Request) {
+				r.AddCookie(cookie)
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				r.Body = io.NopCloser(strings.NewReader(url.Values{"csrf_token": {cookie.Value}}.Encode()))
+			},
+			expectCode: 200,
+		},
+		{
+			name: "missing token",
+			setup: func(r *http.Request) {
+				r.AddCookie(cookie)
+			},
+			expectCode: 403,
+		},
+		{
+			name: "missing cookie",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-CSRF-Token", cookie.Value)
+			},
+			expectCode: 403,
+		},
+		{
+			name: "unsigned planted cookie",
+			setup: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: "csrf_token", Value: "planted"})
+				r.Header.Set("X-CSRF-Token", "planted")
+			},
+			expectCode: 403,
+		},
+		{
+			name: "cross site fetch metadata",
+			setup: func(r *http.Request) {
+				r.AddCookie(cookie)
+				r.Header.Set("X-CSRF-Token", cookie.Value)
+				r.Header.Set("Sec-Fetch-Site", "cross-site")
+			},
+			expectCode: 403,
+		},
+		{
+			name: "foreign origin",
+			setup: func(r *http.Request) {
+				r.AddCookie(cookie)
+				r.Header.Set("X-CSRF-Token", cookie.Value)
+				r.Header.Set("Origin", "https://evil.com")
+			},
+			expectCode: 403,
+		},
+		{
+			name: "same origin",
+			setup: func(r *http.Request) {
+				r.AddCookie(cookie)
+				r.Header.Set("X-CSRF-Token", cookie.Value)
+				r.Header.Set("Origin", "https://example.com")
+				r.Header.Set("Sec-Fetch-Site", "same-origin")
+			},
+			expectCode: 200,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/api", nil)
+			tc.setup(req)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected status %d, got %d", tc.expectCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCSRF_DoubleSubmitSessionBound(t *testing.T) {
+	h := middleware.CSRF(middleware.CSRFCfg{
+		Key: []byte("secret"),
+		SessionID: func(r *http.Request) string {
+			c, err := r.Cookie("session")
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		},
+	})(testHandler(200, "ok"))
+
+	// the attacker obtains a validly signed token for their own session
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "attacker"})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var token *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "csrf_token" {
+			token = c
+		}
+	}
+	if token == nil {
+		t.Fatal("expected csrf cookie to be set")
+	}
+
+	post := func(session string) int {
+		req := httptest.NewRequest("POST", "http://example.com/api", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		req.AddCookie(token)
+		req.Header.Set("X-CSRF-Token", token.Value)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post("attacker"); code != 200 {
+		t.Errorf("expected status 200 for the issuing session, got %d", code)
+	}
+	if code := post("victim"); code != 403 {
+		t.Errorf("expected token of other session to be rejected, got %d", code)
+	}
+}
+
+func TestCSRF_TrustedOrigin(t *testing.T) {
+	h := middleware.CSRF(middleware.CSRFCfg{TrustedOrigins: []string{"https://app.example.com"}})(testHandler(200, "ok"))
+	cookie := csrfTokenCookie(t, h)
+
+	req := httptest.NewRequest("POST", "http://api.example.com/", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-CSRF-Token", cookie.Value)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Sec-Fetch-Site", "same-site")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestCSRF_Synchronizer(t *testing.T) {
+	cfg := middleware.CSRFCfg{
+		Mode: middleware.CSRFSynchronizer,
+		Key:  []byte("secret"),
+		SessionID: func(r *http.Request) string {
+			c, err := r.Cookie("session")
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/token", middleware.CSRFTokenHandler())
+	mux.Handle("/api", testHandler(200, "ok"))
+	h := middleware.CSRF(cfg)(mux)
+
+	req := httptest.NewRequest("GET", "/token", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var payload struct{ Token string }
+	if err := json.NewDecoder(rec.Body).Decode(&payload); err != nil || payload.Token == "" {
+		t.Fatalf("expected token in response, got %q, err: %v", payload.Token, err)
+	}
+
+	post := func(session, token string) int {
+		req := httptest.NewRequest("POST", "/api", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		req.Header.Set("X-CSRF-Token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post("abc", payload.Token); code != 200 {
+		t.Errorf("expected status 200, got %d", code)
+	}
+	if code := post("other", payload.Token); code != 403 {
+		t.Errorf("expected token of other session to be rejected, got %d", code)
+	}
+}
+
+func TestCSRF_JsonErrorEnvelope(t *testing.T) {
+	m := middleware.New(middleware.Cfg{JsonErrors: true})
+	h := m.Middleware(middleware.CSRF(middleware.CSRFCfg{})(testHandler(200, "ok")))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+
+	want := `{"error":"invalid csrf token","code":403}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
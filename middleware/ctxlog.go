@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+type logEntryKey struct{}
+
+// logEntry is stored in the request context by Logging and Middleware, handlers add attributes
+// to it that are included in the request log line.
+type logEntry struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+	attrs  []slog.Attr
+}
+
+// withLogEntry returns a copy of the request whose context carries the logger enriched with the
+// request attributes
+func withLogEntry(r *http.Request, logger *slog.Logger, rd Redactor) *http.Request {
+	e := &logEntry{logger: logger.With(
+		slog.String("method", r.Method),
+		slog.String("url", redactURL(rd, r)),
+		slog.String("ip", userIp(r)),
+		slog.String("req-id", r.Header.Get(HeaderRequestID)),
+	)}
+	return r.WithContext(context.WithValue(r.Context(), logEntryKey{}, e))
+}
+
+// LoggerFrom returns the logger of Logging or Middleware for the request context, with the
+// method, url, ip, req-id and the attributes added with AddLogAttrs. Without one it returns slog.Default().
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	e, ok := ctx.Value(logEntryKey{}).(*logEntry)
+	if !ok {
+		return slog.Default()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.attrs) == 0 {
+		return e.logger
+	}
+	args := make([]any, len(e.attrs))
+	for i, a := range e.attrs {
+		args[i] = a
+	}
+	return e.logger.With(args...)
+}
+
+// AddLogAttrs adds attributes, e.g. a user or tenant id, to the request log line and to the
+// loggers returned by LoggerFrom afterward. It does nothing outside of Logging or Middleware.
+func AddLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	e, ok := ctx.Value(logEntryKey{}).(*logEntry)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attrs = append(e.attrs, attrs...)
+}
+
+// logAttrs returns the attributes added by the handlers
+func logAttrs(ctx context.Context) []slog.Attr {
+	e, ok := ctx.Value(logEntryKey{}).(*logEntry)
+	if !ok {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]slog.Attr(nil), e.attrs...)
+}
@@ -0,0 +1,58 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestLoggerFrom(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware.AddLogAttrs(r.Context(), slog.String("tenant", "acme"))
+		middleware.LoggerFrom(r.Context()).Info("loading orders")
+	})
+
+	tcs := []struct {
+		name string
+		h    http.Handler
+	}{
+		{name: "logging", h: middleware.Logging(logger)(handler)},
+		{name: "middleware", h: middleware.New(middleware.Cfg{Logger: logger}).Middleware(handler)},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("GET", "/orders", nil)
+			req.Header.Set("Request-Id", "abc")
+			req.RemoteAddr = "192.0.2.1:1234"
+			tc.h.ServeHTTP(httptest.NewRecorder(), req)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("expected handler and request log lines, got %q", buf.String())
+			}
+			if !strings.Contains(lines[0], `msg="loading orders" method=GET url=/orders ip=192.0.2.1:1234 req-id=abc tenant=acme`) {
+				t.Errorf("unexpected handler log line %q", lines[0])
+			}
+			if !strings.Contains(lines[1], "response-code=200") || !strings.Contains(lines[1], "tenant=acme") {
+				t.Errorf("expected added attribute in the request log line, got %q", lines[1])
+			}
+		})
+	}
+}
+
+func TestLoggerFrom_Default(t *testing.T) {
+	if middleware.LoggerFrom(context.Background()) != slog.Default() {
+		t.Error("expected the default logger outside of the middleware")
+	}
+	// does not panic
+	middleware.AddLogAttrs(context.Background(), slog.String("k", "v"))
+}
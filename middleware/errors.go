@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // JSONErrors returns a standalone middleware that intercepts error responses (>= 400)
-// and wraps the body in a JSON envelope: {"error": "...", "code": N}.
+// and wraps the body in a JSON envelope: {"error": "...", "code": N}. Leading and trailing
+// newlines of the message, e.g. the one added by http.Error, are removed like in Middleware.
 func JSONErrors(genericErrs bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -58,8 +60,9 @@ func GenericErrors() func(http.Handler) http.Handler {
 	}
 }
 
+// readErrMsg returns the buffered error message, trimmed like the one of the combined middleware
 func readErrMsg(respWriter *StatWriter) string {
-	msg := respWriter.buf.String()
+	msg := strings.Trim(respWriter.buf.String(), "\n")
 	if respWriter.buf.Truncated() {
 		msg += " [truncated]"
 	}
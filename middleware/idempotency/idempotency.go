@@ -0,0 +1,237 @@
+// Package idempotency implements the Idempotency-Key HTTP header (IETF draft
+// draft-ietf-httpapi-idempotency-key-header) as a middleware, so that clients can safely
+// retry non-idempotent requests like POST.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+	"github.com/go-bumbu/http/middleware/auth"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+	maxKeyLength   = 255
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// Cfg configures the idempotency middleware
+type Cfg struct {
+	Store Store
+	// TTL defines how long a response is kept for replay, default 24h
+	TTL time.Duration
+	// LockTTL bounds how long an in-progress key stays locked if the instance handling it dies, default 1 minute.
+	// The lock is extended while the handler is running.
+	LockTTL time.Duration
+	// Methods that honour the header, default POST and PATCH
+	Methods []string
+	// Required rejects requests without the header with 400
+	Required bool
+	// MaxBodyBytes limits the request body read to compute the fingerprint, default 1MB
+	MaxBodyBytes int64
+	// MaxResponseBytes limits the size of a stored response body, default 1MB. For larger responses
+	// only the status and headers are stored and replayed.
+	MaxResponseBytes int
+	Logger           *slog.Logger
+}
+
+// New returns a middleware that executes a request only once per Idempotency-Key:
+//   - the first request locks the key, runs the handler and stores the full response, of responses
+//     larger than MaxResponseBytes only the status and headers
+//   - repeated requests with the same key and payload receive the stored response
+//   - a repeated request while the first one is still running gets 409 Conflict
+//   - a request reusing a key with a different payload gets 422 Unprocessable Entity
+//
+// Keys are scoped by the authenticated principal (see middleware/auth), so clients can't read
+// each other's responses. Server errors (5xx) are not stored, so the client can retry them.
+func New(cfg Cfg) func(http.Handler) http.Handler {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.LockTTL == 0 {
+		cfg.LockTTL = time.Minute
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxResponseBytes == 0 {
+		cfg.MaxResponseBytes = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(cfg.Methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(HeaderKey)
+			if key == "" {
+				if cfg.Required {
+					http.Error(w, "missing "+HeaderKey+" header", http.StatusBadRequest)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				http.Error(w, "invalid "+HeaderKey+" header", http.StatusBadRequest)
+				return
+			}
+
+			fingerprint, err := fingerprintRequest(r, cfg.MaxBodyBytes)
+			if errors.Is(err, errBodyTooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "unable to read request body", http.StatusBadRequest)
+				return
+			}
+			storeKey := scopedKey(r, key)
+
+			existing, locked, err := cfg.Store.Lock(r.Context(), storeKey, fingerprint, cfg.LockTTL)
+			if err != nil {
+				cfg.logErr(r, "locking idempotency key", err)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			if !locked {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					http.Error(w, HeaderKey+" was used with a different request payload", http.StatusUnprocessableEntity)
+				case !existing.Done:
+					http.Error(w, "a request with the same "+HeaderKey+" is being processed", http.StatusConflict)
+				default:
+					replay(w, existing)
+				}
+				return
+			}
+
+			// the result is recorded even if the client went away, its retry needs to find it
+			storeCtx := context.WithoutCancel(r.Context())
+			cw := middleware.NewCaptureWriter(w, cfg.MaxResponseBytes)
+			completed := false
+			stopExtend := cfg.extendLock(storeCtx, r, storeKey)
+			defer func() {
+				stopExtend()
+				// release the key if the handler panicked or the response can't be stored
+				if !completed {
+					if err := cfg.Store.Unlock(storeCtx, storeKey); err != nil {
+						cfg.logErr(r, "unlocking idempotency key", err)
+					}
+				}
+			}()
+			next.ServeHTTP(cw, r)
+			stopExtend()
+
+			if middleware.IsServerErr(cw.StatusCode()) {
+				return
+			}
+			entry := Entry{
+				Fingerprint: fingerprint,
+				StatusCode:  cw.StatusCode(),
+				Header:      cw.CapturedHeader(),
+			}
+			if cw.Truncated() {
+				// the side effect happened, a retry must not run it again even if the body can't be replayed
+				entry.BodyOmitted = true
+				entry.Header.Del("Content-Length")
+			} else {
+				entry.Body = bytes.Clone(cw.Body())
+			}
+			if err := cfg.Store.Complete(storeCtx, storeKey, entry, cfg.TTL); err != nil {
+				cfg.logErr(r, "storing idempotent response", err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// extendLock renews the lock every half LockTTL until the returned function is called, the
+// function can be called several times
+func (cfg Cfg) extendLock(ctx context.Context, r *http.Request, key string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(cfg.LockTTL / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := cfg.Store.Extend(ctx, key, cfg.LockTTL); err != nil {
+					cfg.logErr(r, "extending idempotency lock", err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+func replay(w http.ResponseWriter, e *Entry) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set(HeaderReplayed, "true")
+	w.WriteHeader(e.StatusCode)
+	_, _ = w.Write(e.Body)
+}
+
+// fingerprintRequest hashes method, path and body; the body is restored for the handler
+func fingerprintRequest(r *http.Request, maxBytes int64) (string, error) {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		if err != nil {
+			return "", err
+		}
+		if int64(len(body)) > maxBytes {
+			return "", errBodyTooLarge
+		}
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		_, _ = h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func scopedKey(r *http.Request, key string) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return p.Name + "\x00" + key
+	}
+	return "\x00" + key
+}
+
+func (cfg Cfg) logErr(r *http.Request, msg string, err error) {
+	if cfg.Logger != nil {
+		cfg.Logger.ErrorContext(r.Context(), msg, slog.Any("err", err), slog.String("url", r.RequestURI))
+	}
+}
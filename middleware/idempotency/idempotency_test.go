@@ -0,0 +1,225 @@
+package idempotency_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware/auth"
+	"github.com/go-bumbu/http/middleware/idempotency"
+)
+
+// orderHandler creates a new order id on every execution
+func orderHandler(calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", fmt.Sprintf("/orders/%d", n))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, "order %d: %s", n, body)
+	})
+}
+
+func post(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotency.HeaderKey, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotency_Replay(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotency.New(idempotency.Cfg{})(orderHandler(&calls))
+
+	first := post(h, "key-1", "pizza")
+	second := post(h, "key-1", "pizza")
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls.Load())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed response, got %d %q", second.Code, second.Body.String())
+	}
+	if second.Header().Get("Location") != "/orders/1" {
+		t.Errorf("expected headers to be replayed, got %v", second.Header())
+	}
+	if second.Header().Get(idempotency.HeaderReplayed) != "true" {
+		t.Error("expected replay header")
+	}
+
+	// other keys and requests without key are executed
+	post(h, "key-2", "pizza")
+	post(h, "", "pizza")
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 executions, got %d", calls.Load())
+	}
+}
+
+func TestIdempotency_PayloadMismatch(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotency.New(idempotency.Cfg{})(orderHandler(&calls))
+
+	post(h, "key-1", "pizza")
+	rec := post(h, "key-1", "pasta")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", rec.Code)
+	}
+}
+
+func TestIdempotency_ConcurrentRequests(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		orderHandler(&calls).ServeHTTP(w, r)
+	})
+	h := idempotency.New(idempotency.Cfg{})(slow)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		post(h, "key-1", "pizza")
+	}()
+
+	// wait until the first request holds the lock
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 100; i++ {
+		time.Sleep(time.Millisecond)
+		rec = post(h, "key-1", "pizza")
+		if rec.Code == http.StatusConflict {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 while the first request is in progress, got %d", rec.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected handler to run once, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotency_ServerErrorsAreNotStored(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotency.New(idempotency.Cfg{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "db down", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post(h, "key-1", "pizza")
+	rec := post(h, "key-1", "pizza")
+	if rec.Code != http.StatusCreated || calls.Load() != 2 {
+		t.Errorf("expected retry after a server error, got %d after %d calls", rec.Code, calls.Load())
+	}
+}
+
+func TestIdempotency_ScopedByPrincipal(t *testing.T) {
+	var calls atomic.Int32
+	authn := auth.Middleware(auth.Basic{Verifier: auth.StaticUsers{"alice": "a", "bob": "b"}})
+	h := authn(idempotency.New(idempotency.Cfg{})(orderHandler(&calls)))
+
+	for _, user := range []string{"alice", "bob"} {
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader("pizza"))
+		req.Header.Set(idempotency.HeaderKey, "key-1")
+		req.SetBasicAuth(user, user[:1])
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected keys of different principals not to collide, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotency_Required(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotency.New(idempotency.Cfg{Required: true})(orderHandler(&calls))
+	if rec := post(h, "", "pizza"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestIdempotency_LargeResponseNotRerun(t *testing.T) {
+	var calls atomic.Int32
+	h := idempotency.New(idempotency.Cfg{MaxResponseBytes: 5})(orderHandler(&calls))
+
+	post(h, "key-1", "pizza")
+	rec := post(h, "key-1", "pizza")
+	if calls.Load() != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls.Load())
+	}
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/orders/1" || rec.Body.Len() != 0 {
+		t.Errorf("expected status and headers to be replayed without body, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
+
+// ctxStore fails like a store using the context, e.g. a database, once the context is canceled
+type ctxStore struct {
+	*idempotency.MemoryStore
+}
+
+func (s ctxStore) Complete(ctx context.Context, key string, e idempotency.Entry, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryStore.Complete(ctx, key, e, ttl)
+}
+
+func TestIdempotency_ClientGone(t *testing.T) {
+	var calls atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	// the client times out while the handler runs
+	h := idempotency.New(idempotency.Cfg{Store: ctxStore{idempotency.NewMemoryStore()}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			orderHandler(&calls).ServeHTTP(w, r)
+		}))
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader("pizza")).WithContext(ctx)
+	req.Header.Set(idempotency.HeaderKey, "key-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := post(h, "key-1", "pizza")
+	if rec.Header().Get(idempotency.HeaderReplayed) != "true" || calls.Load() != 1 {
+		t.Errorf("expected the response to be stored although the client went away, got %d after %d calls", rec.Code, calls.Load())
+	}
+}
+
+func TestIdempotency_LockExtended(t *testing.T) {
+	var calls atomic.Int32
+	var started atomic.Int32
+	release := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if started.Add(1) == 1 {
+			<-release
+		}
+		orderHandler(&calls).ServeHTTP(w, r)
+	})
+	h := idempotency.New(idempotency.Cfg{LockTTL: 20 * time.Millisecond})(slow)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		post(h, "key-1", "pizza")
+	}()
+	time.Sleep(80 * time.Millisecond)
+	rec := post(h, "key-1", "pizza")
+	close(release)
+	<-done
+	if rec.Code != http.StatusConflict || calls.Load() != 1 {
+		t.Errorf("expected the lock to be held beyond LockTTL, got %d after %d calls", rec.Code, calls.Load())
+	}
+}
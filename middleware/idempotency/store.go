@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is the stored state of an idempotency key
+type Entry struct {
+	Fingerprint string
+	Done        bool // false while the first request is still being processed
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	// BodyOmitted is set if the response was larger than MaxResponseBytes, only the status and
+	// the headers are replayed
+	BodyOmitted bool
+}
+
+// Store persists idempotency entries. Implementations need to make Lock atomic, so that only one
+// of several concurrent requests with the same key gets to execute the handler.
+type Store interface {
+	// Lock creates an in-progress entry for key. If an entry already exists it is returned
+	// and locked is false.
+	Lock(ctx context.Context, key, fingerprint string, ttl time.Duration) (existing *Entry, locked bool, err error)
+	// Extend renews the lock of an in-progress key, it is called while a long running handler executes
+	Extend(ctx context.Context, key string, ttl time.Duration) error
+	// Complete stores the final response of a locked key
+	Complete(ctx context.Context, key string, e Entry, ttl time.Duration) error
+	// Unlock removes the entry of a key, so that the request can be retried
+	Unlock(ctx context.Context, key string) error
+}
+
+// MemoryStore is an in-memory reference Store, entries are lost on restart and not shared
+// between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memEntry
+	lastSweep time.Time
+}
+
+type memEntry struct {
+	entry   Entry
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memEntry{}}
+}
+
+func (m *MemoryStore) Lock(_ context.Context, key, fingerprint string, ttl time.Duration) (*Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	if e, ok := m.entries[key]; ok && now.Before(e.expires) {
+		cp := e.entry
+		return &cp, false, nil
+	}
+	m.entries[key] = memEntry{entry: Entry{Fingerprint: fingerprint}, expires: now.Add(ttl)}
+	return nil, true, nil
+}
+
+func (m *MemoryStore) Extend(_ context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok && !e.entry.Done {
+		e.expires = time.Now().Add(ttl)
+		m.entries[key] = e
+	}
+	return nil
+}
+
+func (m *MemoryStore) Complete(_ context.Context, key string, e Entry, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.Done = true
+	m.entries[key] = memEntry{entry: e, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryStore) Unlock(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// sweep removes expired entries at most once a minute
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	for k, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	m.lastSweep = now
+}
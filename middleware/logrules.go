@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRules controls which requests Logging and Middleware log, at which level and what data.
+//
+// Path patterns are exact paths, path.Match globs like "/api/*/status", or end in "/**" to match
+// a whole subtree, e.g. "/static/**".
+type LogRules struct {
+	// ExcludePaths are never logged, e.g. health checks and metrics scrapes
+	ExcludePaths []string
+	// RouteLevels sets the level of successful (< 400) requests by path pattern, the longest matching pattern wins
+	RouteLevels map[string]slog.Level
+	// StatusLevels sets the level by status class: 1 to 5 for 1xx to 5xx
+	StatusLevels map[int]slog.Level
+	// SampleRate is the fraction of successful requests logged, between 0 and 1; 0 logs all of them
+	SampleRate float64
+	// MaxPerSecond caps the successful requests logged per second, 0 is unlimited
+	MaxPerSecond int
+	// SlowThreshold logs slower requests at least at WARN, they are never sampled out
+	SlowThreshold time.Duration
+	// Redactor scrubs the url, headers and error body before logging; when set, the headers it allows
+	// are logged as req-header and resp-header groups. See Redaction
+	Redactor Redactor
+	// CaptureBody logs request and response bodies, for debugging
+	CaptureBody *BodyCapture
+}
+
+// logRules is the compiled form of LogRules, a nil *logRules logs everything
+type logRules struct {
+	LogRules
+
+	mu          sync.Mutex
+	second      int64
+	secondCount int
+}
+
+func newLogRules(r LogRules) *logRules {
+	return &logRules{LogRules: r}
+}
+
+func (l *logRules) redactor() Redactor {
+	if l == nil {
+		return nil
+	}
+	return l.Redactor
+}
+
+// level returns the log level of a request, or false if it should not be logged
+func (l *logRules) level(r *http.Request, statusCode int, dur time.Duration) (slog.Level, bool) {
+	level := slog.LevelInfo
+	if IsServerErr(statusCode) {
+		level = slog.LevelError
+	}
+	if l == nil {
+		return level, true
+	}
+	if matchAny(l.ExcludePaths, r.URL.Path) {
+		return 0, false
+	}
+
+	if lvl, ok := l.StatusLevels[statusCode/100]; ok {
+		level = lvl
+	}
+	if !IsStatusError(statusCode) {
+		matched := ""
+		for pattern, lvl := range l.RouteLevels {
+			if len(pattern) > len(matched) && matchPath(pattern, r.URL.Path) {
+				matched = pattern
+				level = lvl
+			}
+		}
+	}
+
+	slow := l.SlowThreshold > 0 && dur >= l.SlowThreshold
+	if slow {
+		level = max(level, slog.LevelWarn)
+	}
+	if slow || IsStatusError(statusCode) {
+		return level, true
+	}
+	return level, l.sample()
+}
+
+func (l *logRules) sample() bool {
+	if l.SampleRate > 0 && rand.Float64() >= l.SampleRate { //nolint:gosec // sampling does not need a secure random source
+		return false
+	}
+	if l.MaxPerSecond <= 0 {
+		return true
+	}
+	now := time.Now().Unix()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now != l.second {
+		l.second = now
+		l.secondCount = 0
+	}
+	if l.secondCount >= l.MaxPerSecond {
+		return false
+	}
+	l.secondCount++
+	return true
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(pattern, p string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	if pattern == p {
+		return true
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
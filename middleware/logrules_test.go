@@ -0,0 +1,110 @@
+package middleware_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestLoggingWithRules_Levels(t *testing.T) {
+	rules := middleware.LogRules{
+		ExcludePaths: []string{"/healthz", "/static/**", "/api/*/status"},
+		RouteLevels:  map[string]slog.Level{"/api/**": slog.LevelDebug, "/api/orders": slog.LevelWarn},
+		StatusLevels: map[int]slog.Level{4: slog.LevelWarn},
+	}
+	tcs := []struct {
+		name       string
+		path       string
+		statusCode int
+		expect     string // empty if not logged
+	}{
+		{name: "default", path: "/", statusCode: 200, expect: "INFO"},
+		{name: "excluded exact", path: "/healthz", statusCode: 200},
+		{name: "excluded subtree", path: "/static/css/main.css", statusCode: 200},
+		{name: "excluded glob", path: "/api/v1/status", statusCode: 500},
+		{name: "route level", path: "/api/users", statusCode: 200, expect: "DEBUG"},
+		{name: "longest route wins", path: "/api/orders", statusCode: 200, expect: "WARN"},
+		{name: "status class", path: "/", statusCode: 404, expect: "WARN"},
+		{name: "route level not applied to errors", path: "/api/users", statusCode: 500, expect: "ERROR"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, logger := newMemSlog()
+			h := middleware.LoggingWithRules(logger, rules)(testHandler(tc.statusCode, "msg"))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+
+			got := buf.String()
+			if tc.expect == "" {
+				if got != "" {
+					t.Errorf("expected no log line, got %q", got)
+				}
+				return
+			}
+			if !strings.HasPrefix(got, tc.expect+" ") {
+				t.Errorf("expected level %s, got %q", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestLoggingWithRules_Sampling(t *testing.T) {
+	tcs := []struct {
+		name       string
+		rules      middleware.LogRules
+		statusCode int
+		handler    http.Handler
+		expect     func(n int) bool
+	}{
+		{
+			name:       "sample rate drops successes",
+			rules:      middleware.LogRules{SampleRate: 0.000001},
+			statusCode: 200,
+			expect:     func(n int) bool { return n < 3 },
+		},
+		{
+			name:       "errors are always logged",
+			rules:      middleware.LogRules{SampleRate: 0.000001, MaxPerSecond: 1},
+			statusCode: 500,
+			expect:     func(n int) bool { return n == 20 },
+		},
+		{
+			// the window can roll over a second boundary during the test
+			name:       "per second cap",
+			rules:      middleware.LogRules{MaxPerSecond: 2},
+			statusCode: 200,
+			expect:     func(n int) bool { return n >= 2 && n <= 4 },
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, logger := newMemSlog()
+			h := middleware.LoggingWithRules(logger, tc.rules)(testHandler(tc.statusCode, "msg"))
+			for i := 0; i < 20; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+			if n := strings.Count(buf.String(), "method="); !tc.expect(n) {
+				t.Errorf("unexpected number of log lines: %d", n)
+			}
+		})
+	}
+}
+
+func TestMiddleware_LogRulesSlow(t *testing.T) {
+	buf, logger := newMemSlog()
+	m := middleware.New(middleware.Cfg{
+		Logger:   logger,
+		LogRules: &middleware.LogRules{SampleRate: 0.000001, SlowThreshold: 5 * time.Millisecond},
+	})
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	if !strings.HasPrefix(buf.String(), "WARN method=GET url=/slow") {
+		t.Errorf("expected slow request logged at WARN, got %q", buf.String())
+	}
+}
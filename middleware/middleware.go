@@ -5,11 +5,13 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/go-bumbu/http/lib/limitio"
+	"github.com/go-bumbu/http/middleware/auth"
 )
 
 type Cfg struct {
@@ -17,9 +19,21 @@ type Cfg struct {
 	GenericErrs  bool // print generic error messages instead of the actual one
 	PanicRecover bool
 	Logger       *slog.Logger
+	LogRules     *LogRules // optional, exclude, sample and re-level logged requests
 	PromHisto    Histogram
+
+	// Chain replaces the single pass of logging, metrics, errors and panic recovery with an ordered
+	// list of slots, the first one is the outermost, e.g. DefaultChain. See Validate for the rules.
+	Chain []Slot
+	// Skip bypasses a slot of the Chain for a request, e.g. logging and metrics for health checks
+	Skip           func(r *http.Request, slot Slot) bool
+	TrustedProxies []netip.Prefix // used by the real-ip slot
+	Timeout        time.Duration  // used by the timeout slot
+	Compress       CompressCfg    // used by the compression slot
 }
 
+// New returns the combined middleware, it panics if the Chain is invalid; use Cfg.Validate to
+// check a configuration first.
 func New(cfg Cfg) *Middleware {
 	m := Middleware{
 		jsonErrors:   cfg.JsonErrors,
@@ -28,6 +42,15 @@ func New(cfg Cfg) *Middleware {
 		hist:         cfg.PromHisto,
 		logger:       cfg.Logger,
 	}
+	if cfg.LogRules != nil {
+		m.logRules = newLogRules(*cfg.LogRules)
+	}
+	if len(cfg.Chain) > 0 {
+		if err := cfg.Validate(); err != nil {
+			panic(fmt.Sprintf("invalid middleware chain: %v", err))
+		}
+		m.chainCfg = &cfg
+	}
 	return &m
 }
 
@@ -42,22 +65,35 @@ func New(cfg Cfg) *Middleware {
 //
 //   - Histogram: use NewPromHistogram to create an histogram used to capture prometheus metrics about every request
 //     if left empty, no prometheus metric will be captured
+//   - Chain: if set, the middleware of the listed slots are chained in order instead, e.g. to add
+//     request ids, real ip resolution, timeouts and compression
 type Middleware struct {
 	jsonErrors   bool
 	genericErrs  bool
 	panicRecover bool
 	hist         Histogram
 	logger       *slog.Logger
+	logRules     *logRules
+	chainCfg     *Cfg
 }
 
 // Middleware is an HTTP middleware that checks the Config and applies logic based on it.
 func (c *Middleware) Middleware(next http.Handler) http.Handler {
+	if c.chainCfg != nil {
+		return c.chain(*c.chainCfg, next)
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		timeStart := time.Now()
 		// teeOnErr: when we won't modify the body (no genericErrs, no jsonErrors), tee so the
 		// client receives it during e.g. reverse proxy copy—avoids indefinite hang on 401.
 		teeOnErr := !c.genericErrs && !c.jsonErrors
 		respWriter := NewWriter(w, true, teeOnErr)
+		r = r.WithContext(trackRoute(auth.Track(r.Context())))
+		respWriter.CountBody(r)
+		if c.logger != nil {
+			r = withLogEntry(r, c.logger, c.logRules.redactor())
+			c.logRules.capture(r, respWriter)
+		}
 
 		if c.panicRecover {
 			defer func() {
@@ -66,7 +102,7 @@ func (c *Middleware) Middleware(next http.Handler) http.Handler {
 					if c.logger != nil {
 						c.logger.Error("panic recovered",
 							slog.String("method", r.Method),
-							slog.String("url", r.RequestURI),
+							slog.String("url", redactURL(c.logRules.redactor(), r)),
 							slog.String("panic", fmt.Sprint(rec)),
 							slog.String("stack", string(stack)),
 						)
@@ -90,8 +126,9 @@ func (c *Middleware) finalize(w http.ResponseWriter, r *http.Request, respWriter
 	timeDiff := time.Since(timeStart)
 
 	errMsg := c.getErrMsg(respWriter.statusCode, respWriter.buf)
-	c.log(r, respWriter.StatusCode(), errMsg, timeDiff)
+	c.log(r, respWriter, errMsg, timeDiff)
 
+	errMsg = truncMarker(errMsg, respWriter.buf)
 	if c.genericErrs {
 		errMsg = http.StatusText(respWriter.StatusCode())
 	}
@@ -114,10 +151,11 @@ func (c *Middleware) finalize(w http.ResponseWriter, r *http.Request, respWriter
 		respWriter.flushHeader()
 	}
 
-	c.observe(r, respWriter.StatusCode(), timeDiff)
+	c.observe(r, respWriter, timeDiff)
 }
 
-// getErrMsg returns the error handlerMsg in case of an error response or empty string
+// getErrMsg returns the error handlerMsg in case of an error response or empty string, see
+// truncMarker for incomplete messages
 func (c *Middleware) getErrMsg(code int, buf *limitio.LimitedBuf) string {
 	if !IsStatusError(code) {
 		return ""
@@ -127,9 +165,14 @@ func (c *Middleware) getErrMsg(code int, buf *limitio.LimitedBuf) string {
 	if err != nil && c.logger != nil {
 		c.logger.Error("error while reading buffer error handlerMsg:", slog.Any("err", err))
 	}
-	msg := strings.Trim(string(msgB), "\n")
-	if buf.Truncated() {
-		msg += " [truncated]"
+	return strings.Trim(string(msgB), "\n")
+}
+
+// truncMarker marks msg as incomplete if buf was truncated. The marker is added after the
+// redaction, so that it doesn't make a JSON body unparsable.
+func truncMarker(msg string, buf *limitio.LimitedBuf) string {
+	if msg != "" && buf != nil && buf.Truncated() {
+		return msg + " [truncated]"
 	}
 	return msg
 }
@@ -126,3 +126,24 @@ func TestJsonOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestJSONErrors_TrimsNewlines(t *testing.T) {
+	// http.Error appends a newline to the message, both JSONErrors and Middleware drop it
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "DB connection broken", http.StatusInternalServerError)
+	})
+	handlers := map[string]http.Handler{
+		"standalone": middleware.JSONErrors(false)(th),
+		"middleware": middleware.New(middleware.Cfg{JsonErrors: true}).Middleware(th),
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			want := `{"error":"DB connection broken","code":500}`
+			if diff := cmp.Diff(rec.Body.String(), want); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
@@ -19,33 +19,63 @@ func Metrics(hist Histogram) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			timeStart := time.Now()
 			respWriter := NewWriter(w, false, false)
+			r = r.WithContext(trackRoute(r.Context()))
+			respWriter.CountBody(r)
 
 			next.ServeHTTP(respWriter, r)
 			timeDiff := time.Since(timeStart)
 
-			m.observe(r, respWriter.StatusCode(), timeDiff)
+			m.observe(r, respWriter, timeDiff)
 		})
 	}
 }
 
-func (c *Middleware) observe(r *http.Request, statusCode int, dur time.Duration) {
+func (c *Middleware) observe(r *http.Request, respWriter *StatWriter, dur time.Duration) {
+	statusCode := respWriter.StatusCode()
+	addr := metricAddr(r)
+	if respWriter.Hijacked() && c.hist.hijacked != nil {
+		c.hist.hijacked.With(prometheus.Labels{
+			"method": r.Method,
+			"addr":   addr,
+		}).Observe(dur.Seconds())
+		return
+	}
+	if c.hist.reqSize != nil {
+		labels := prometheus.Labels{"method": r.Method, "addr": addr}
+		c.hist.reqSize.With(labels).Observe(float64(respWriter.BytesRead()))
+		c.hist.respSize.With(labels).Observe(float64(respWriter.BytesWritten()))
+	}
 	if c.hist.h != nil {
 		isErrorStr := strconv.FormatBool(IsStatusError(statusCode))
-
-		// todo don't print all paths, this creates too much cardinality
 		c.hist.h.With(prometheus.Labels{
 			"type":    r.Proto,
 			"status":  strconv.Itoa(statusCode),
 			"method":  r.Method,
-			"addr":    r.URL.Path,
+			"addr":    addr,
 			"isError": isErrorStr,
 		}).Observe(dur.Seconds())
 	}
 }
 
+// metricAddr returns the route name if set, see WithRoute, otherwise the path. Requests not
+// matched by a router are labeled RouteUnmatched.
+// TODO without a router every path is a separate label value, which can create too much cardinality
+func metricAddr(r *http.Request) string {
+	if route := RouteFromContext(r.Context()); route != "" {
+		return route
+	}
+	return r.URL.Path
+}
+
 // Histogram ensures that when we call observe the request metric has been initialized correctly with NewPromHistogram
+// Hijacked connections (e.g. WebSockets) are recorded with status 101, unless a separate histogram was
+// added with WithHijackedBuckets.
 type Histogram struct {
-	h *prometheus.HistogramVec
+	h        *prometheus.HistogramVec
+	hijacked *prometheus.HistogramVec
+	reqSize  *prometheus.HistogramVec
+	respSize *prometheus.HistogramVec
+	prefix   string
 }
 
 func NewPromHistogram(prefix string, buckets []float64, registry prometheus.Registerer) (Histogram, error) {
@@ -81,6 +111,85 @@ func NewPromHistogram(prefix string, buckets []float64, registry prometheus.Regi
 	}
 
 	return Histogram{
-		h: histogram,
+		h:      histogram,
+		prefix: prefix,
 	}, nil
 }
+
+// WithHijackedBuckets registers a separate histogram for the lifetime of hijacked connections,
+// so long-lived WebSockets don't distort the request durations, e.g. with buckets in minutes.
+func (h Histogram) WithHijackedBuckets(buckets []float64, registry prometheus.Registerer) (Histogram, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if len(buckets) == 0 {
+		buckets = []float64{1, 10, 60, 300, 900, 1800, 3600, 4 * 3600, 24 * 3600}
+	}
+	prefix := h.prefix
+	if prefix == "" {
+		prefix = "requests"
+	}
+
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prefix,
+		Subsystem: "http",
+		Name:      "hijacked_duration_seconds",
+		Help:      "Duration of hijacked HTTP connections, e.g. WebSockets, for different paths and methods",
+		Buckets:   buckets,
+	},
+		[]string{
+			"method",
+			"addr",
+		},
+	)
+	if err := registry.Register(histogram); err != nil {
+		return Histogram{}, fmt.Errorf("registering prometheus histogram: %w", err)
+	}
+	h.hijacked = histogram
+	return h, nil
+}
+
+// WithSizeBuckets registers histograms of the request and response body sizes in bytes, the
+// default buckets go from 100B to 100MB.
+func (h Histogram) WithSizeBuckets(buckets []float64, registry prometheus.Registerer) (Histogram, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if len(buckets) == 0 {
+		buckets = prometheus.ExponentialBuckets(100, 10, 7)
+	}
+	prefix := h.prefix
+	if prefix == "" {
+		prefix = "requests"
+	}
+
+	newHist := func(name, help string) (*prometheus.HistogramVec, error) {
+		histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+			Buckets:   buckets,
+		},
+			[]string{
+				"method",
+				"addr",
+			},
+		)
+		if err := registry.Register(histogram); err != nil {
+			return nil, fmt.Errorf("registering prometheus histogram: %w", err)
+		}
+		return histogram, nil
+	}
+	reqSize, err := newHist("request_size_bytes", "Size of the HTTP request bodies read by the handlers")
+	if err != nil {
+		return Histogram{}, err
+	}
+	respSize, err := newHist("response_size_bytes", "Size of the HTTP response bodies written by the handlers")
+	if err != nil {
+		return Histogram{}, err
+	}
+	h.reqSize = reqSize
+	h.respSize = respSize
+	return h, nil
+}
@@ -91,3 +91,56 @@ func TestPromMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestPromMiddleware_Hijacked(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hist, err := middleware.NewPromHistogram("", nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err = hist.WithHijackedBuckets(nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveHijacked(t, middleware.Metrics(hist)(http.HandlerFunc(hijackEcho)))
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `requests_http_hijacked_duration_seconds_count{addr="/ws",method="GET"} 1`) {
+		t.Errorf("expected hijacked connection in the separate histogram, got:\n%s", body)
+	}
+	if strings.Contains(body, "requests_http_duration_seconds_count") {
+		t.Errorf("expected hijacked connection not to be recorded as request")
+	}
+}
+
+func TestPromMiddleware_Sizes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hist, err := middleware.NewPromHistogram("", nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err = hist.WithSizeBuckets([]float64{10, 100}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := middleware.Metrics(hist)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte(strings.Repeat("x", 50)))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", strings.NewReader("12345")))
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`requests_http_request_size_bytes_bucket{addr="/upload",method="POST",le="10"} 1`,
+		`requests_http_response_size_bytes_bucket{addr="/upload",method="POST",le="10"} 0`,
+		`requests_http_response_size_bytes_bucket{addr="/upload",method="POST",le="100"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("response does not contains expected line: %s", line)
+		}
+	}
+}
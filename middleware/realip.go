@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP returns a middleware that resolves the client address of requests coming through the
+// trusted proxies, e.g. a load balancer, from X-Forwarded-For or X-Real-Ip. The address is set
+// as r.RemoteAddr and as X-Real-Ip, which is logged as ip. Forwarding headers sent by untrusted
+// peers are ignored, so clients can't spoof their address.
+func RealIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	trusted := func(ip netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip, ok := remoteIP(r.RemoteAddr); ok {
+				client := ip
+				if trusted(ip) {
+					client = forwardedIP(r, trusted, ip)
+				}
+				r.RemoteAddr = client.String()
+				r.Header.Set("X-Real-Ip", client.String())
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP walks X-Forwarded-For from the closest hop, the first address that is not a
+// trusted proxy is the client
+func forwardedIP(r *http.Request, trusted func(netip.Addr) bool, remote netip.Addr) netip.Addr {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return client
+		}
+		client = ip.Unmap()
+		if !trusted(client) {
+			return client
+		}
+	}
+	if len(hops) == 0 {
+		if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); err == nil {
+			return ip.Unmap()
+		}
+	}
+	return client
+}
+
+func remoteIP(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
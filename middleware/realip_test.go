@@ -0,0 +1,48 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tcs := []struct {
+		name    string
+		remote  string
+		xff     string
+		xRealIP string
+		expect  string
+	}{
+		{name: "direct client", remote: "192.0.2.1:1234", expect: "192.0.2.1"},
+		{name: "spoofed from untrusted", remote: "192.0.2.1:1234", xff: "203.0.113.9", xRealIP: "203.0.113.9", expect: "192.0.2.1"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", xff: "203.0.113.9", expect: "203.0.113.9"},
+		{name: "proxy chain", remote: "10.0.0.1:1234", xff: "198.51.100.7, 203.0.113.9, 10.0.0.2", expect: "203.0.113.9"},
+		{name: "real ip header", remote: "10.0.0.1:1234", xRealIP: "203.0.113.9", expect: "203.0.113.9"},
+		{name: "invalid forwarded", remote: "10.0.0.1:1234", xff: "unknown", expect: "10.0.0.1"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var remote, header string
+			h := middleware.RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				remote, header = r.RemoteAddr, r.Header.Get("X-Real-Ip")
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if tc.xRealIP != "" {
+				req.Header.Set("X-Real-Ip", tc.xRealIP)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if remote != tc.expect || header != tc.expect {
+				t.Errorf("expected %s, got remote %s and header %s", tc.expect, remote, header)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Redactor scrubs request data before it is passed to the logger
+type Redactor interface {
+	// RedactHeader returns the value to log for a request or response header, false skips the header
+	RedactHeader(name, value string) (string, bool)
+	// RedactQuery returns the value to log for a query parameter
+	RedactQuery(name, value string) string
+	// RedactBody returns the captured body to log
+	RedactBody(body string) string
+}
+
+// RedactMask replaces redacted values
+const RedactMask = "[REDACTED]"
+
+// DefaultRedactPatterns match e-mail addresses, bearer tokens and JWTs
+var DefaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`),
+	regexp.MustCompile(`(?i)bearer\s+[a-zA-Z0-9\-._~+/]+=*`),
+	regexp.MustCompile(`eyJ[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]*`),
+}
+
+// sensitiveHeaders are masked even when they are allowed
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Redaction is the default Redactor, its zero value logs no headers and leaves query and body untouched
+type Redaction struct {
+	// Headers is the allowlist of headers to log, Authorization, Cookie and Set-Cookie are always masked
+	Headers []string
+	// Query are the query parameters whose values are masked
+	Query []string
+	// BodyPatterns are replaced in the body, see DefaultRedactPatterns
+	BodyPatterns []*regexp.Regexp
+	// JSONPaths are masked in JSON bodies, dot separated with "*" matching any key or array element,
+	// e.g. "user.email" or "items.*.token". If set, bodies that are not valid JSON, e.g. truncated
+	// ones, are replaced by RedactMask as a whole, since the paths can't be located in them.
+	JSONPaths []string
+}
+
+func (rd Redaction) RedactHeader(name, value string) (string, bool) {
+	if !slices.ContainsFunc(rd.Headers, func(h string) bool { return strings.EqualFold(h, name) }) {
+		return "", false
+	}
+	if slices.ContainsFunc(sensitiveHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+		return RedactMask, true
+	}
+	return value, true
+}
+
+func (rd Redaction) RedactQuery(name, value string) string {
+	if slices.Contains(rd.Query, name) {
+		return RedactMask
+	}
+	return value
+}
+
+func (rd Redaction) RedactBody(body string) string {
+	if len(rd.JSONPaths) > 0 {
+		body = redactJSON(body, rd.JSONPaths)
+	}
+	for _, re := range rd.BodyPatterns {
+		body = re.ReplaceAllString(body, RedactMask)
+	}
+	return body
+}
+
+// redactJSON masks the values at paths, bodies that are not valid JSON are masked completely
+func redactJSON(body string, paths []string) string {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return RedactMask
+	}
+	for _, p := range paths {
+		v = maskPath(v, strings.Split(p, "."))
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return RedactMask
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func maskPath(v any, path []string) any {
+	if len(path) == 0 {
+		return RedactMask
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = maskPath(child, path[1:])
+			}
+		}
+	case []any:
+		// arrays without "*" are traversed transparently
+		rest := path
+		if path[0] == "*" {
+			rest = path[1:]
+		}
+		for i, child := range t {
+			t[i] = maskPath(child, rest)
+		}
+	}
+	return v
+}
+
+// redactURL returns the request URI with the query parameters passed through the Redactor
+func redactURL(rd Redactor, r *http.Request) string {
+	if rd == nil || r.URL.RawQuery == "" {
+		return r.RequestURI
+	}
+	// keep the original order and encoding, only replaced values are written unescaped
+	pairs := strings.Split(r.URL.RawQuery, "&")
+	for i, pair := range pairs {
+		rawName, rawValue, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			pairs[i] = rawName + "=" + RedactMask
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			pairs[i] = rawName + "=" + RedactMask
+			continue
+		}
+		if logged := rd.RedactQuery(name, value); logged != value {
+			pairs[i] = rawName + "=" + logged
+		}
+	}
+	return r.URL.Path + "?" + strings.Join(pairs, "&")
+}
+
+// redactHeaders returns the headers allowed by the Redactor as attributes sorted by name
+func redactHeaders(rd Redactor, h http.Header) []any {
+	if rd == nil {
+		return nil
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var attrs []any
+	for _, name := range names {
+		var logged []string
+		for _, v := range h[name] {
+			if v, ok := rd.RedactHeader(name, v); ok {
+				logged = append(logged, v)
+			}
+		}
+		if len(logged) > 0 {
+			attrs = append(attrs, slog.String(name, strings.Join(logged, ", ")))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,119 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestRedaction_Body(t *testing.T) {
+	tcs := []struct {
+		name   string
+		rd     middleware.Redaction
+		body   string
+		expect string
+	}{
+		{
+			name:   "default patterns",
+			rd:     middleware.Redaction{BodyPatterns: middleware.DefaultRedactPatterns},
+			body:   "user jane@example.com sent Bearer abc.def-123 invalid",
+			expect: "user [REDACTED] sent [REDACTED] invalid",
+		},
+		{
+			name:   "custom pattern",
+			rd:     middleware.Redaction{BodyPatterns: []*regexp.Regexp{regexp.MustCompile(`\d{4}-\d{4}`)}},
+			body:   "card 1234-5678 declined",
+			expect: "card [REDACTED] declined",
+		},
+		{
+			name:   "json paths",
+			rd:     middleware.Redaction{JSONPaths: []string{"user.email", "items.*.token", "secret"}},
+			body:   `{"items":[{"id":1,"token":"t1"},{"id":2,"token":"t2"}],"secret":{"a":1},"user":{"email":"a@b.c","name":"jane"}}`,
+			expect: `{"items":[{"id":1,"token":"[REDACTED]"},{"id":2,"token":"[REDACTED]"}],"secret":"[REDACTED]","user":{"email":"[REDACTED]","name":"jane"}}`,
+		},
+		{
+			name:   "not json",
+			rd:     middleware.Redaction{JSONPaths: []string{"secret"}},
+			body:   `{"secret":"abc"`,
+			expect: `[REDACTED]`,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.rd.RedactBody(tc.body); got != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestLogging_Redactor(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	rules := middleware.LogRules{Redactor: middleware.Redaction{
+		Headers:      []string{"User-Agent", "Authorization", "Content-Type"},
+		Query:        []string{"token"},
+		BodyPatterns: middleware.DefaultRedactPatterns,
+	}}
+	h := middleware.LoggingWithRules(logger, rules)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("unknown user jane@example.com"))
+	}))
+
+	req := httptest.NewRequest("GET", "/login?user=jane&token=s3cr3t", nil)
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	req.Header.Set("X-Other", "not logged")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	got := buf.String()
+	for _, expect := range []string{
+		`url="/login?user=jane&token=[REDACTED]"`,
+		`req-header.Authorization=[REDACTED]`,
+		`req-header.User-Agent=curl`,
+		`resp-header.Content-Type=text/plain`,
+		`err-handlerMsg="unknown user [REDACTED]"`,
+	} {
+		if !strings.Contains(got, expect) {
+			t.Errorf("expected %s in log line %q", expect, got)
+		}
+	}
+	for _, leaked := range []string{"s3cr3t", "jane@example.com", "X-Other"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("log line leaks %q: %q", leaked, got)
+		}
+	}
+	// the client still gets the original body
+	if rec.Body.String() != "unknown user jane@example.com" {
+		t.Errorf("unexpected response body %q", rec.Body.String())
+	}
+}
+
+func TestLogging_RedactorTruncatedError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	m := middleware.New(middleware.Cfg{Logger: logger, LogRules: &middleware.LogRules{
+		Redactor: middleware.Redaction{JSONPaths: []string{"password"}},
+	}})
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"password":"s3cr3t","detail":"` + strings.Repeat("x", 2000) + `"}`))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil))
+
+	got := buf.String()
+	if strings.Contains(got, "s3cr3t") {
+		t.Errorf("log line leaks the password of a truncated body: %q", got)
+	}
+	if !strings.Contains(got, `err-handlerMsg="[REDACTED] [truncated]"`) {
+		t.Errorf("expected masked error message with truncation marker, got %q", got)
+	}
+}
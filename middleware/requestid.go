@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// HeaderRequestID is the request id header logged as req-id and forwarded by client.Propagate
+const HeaderRequestID = "Request-Id"
+
+// RequestID returns a middleware that makes sure every request has a Request-Id header: a valid
+// incoming id is kept, otherwise a random one is generated. The id is also set on the response.
+func RequestID() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(HeaderRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+				r.Header.Set(HeaderRequestID, id)
+			}
+			w.Header().Set(HeaderRequestID, id)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validRequestID accepts up to 128 printable ASCII characters, so ids can't inject into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
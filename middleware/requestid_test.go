@@ -0,0 +1,46 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestRequestID(t *testing.T) {
+	tcs := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "generated", incoming: ""},
+		{name: "kept", incoming: "abc-123", keep: true},
+		{name: "invalid replaced", incoming: "abc\n123"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen string
+			h := middleware.RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Get(middleware.HeaderRequestID)
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.incoming != "" {
+				req.Header.Set(middleware.HeaderRequestID, tc.incoming)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Header().Get(middleware.HeaderRequestID) != seen {
+				t.Errorf("expected response id %q, got %q", seen, rec.Header().Get(middleware.HeaderRequestID))
+			}
+			if tc.keep && seen != tc.incoming {
+				t.Errorf("expected incoming id to be kept, got %q", seen)
+			}
+			if !tc.keep && !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(seen) {
+				t.Errorf("expected generated id, got %q", seen)
+			}
+		})
+	}
+}
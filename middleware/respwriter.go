@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-bumbu/http/lib/limitio"
 )
@@ -18,6 +23,15 @@ type StatWriter struct {
 	buf           *limitio.LimitedBuf
 	headerWritten bool
 	bodyForwarded bool // true when body was written to client (via tee)
+	hijacked      bool
+	hijackRead    atomic.Int64
+	hijackWritten atomic.Int64
+	start         time.Time
+	ttfb          time.Duration
+	bytesWritten  int64
+	bytesRead     atomic.Int64        // request body, read from the handler goroutines
+	reqBody       *limitio.LimitedBuf // debug capture of the request body, see BodyCapture
+	respBody      *limitio.LimitedBuf // debug capture of the response body
 }
 
 // NewWriter returns a StatWriter. When interceptBody is true and status is an error
@@ -35,6 +49,7 @@ func NewWriter(w http.ResponseWriter, interceptBody bool, teeOnErr bool) *StatWr
 			Buffer:   bytes.Buffer{},
 			MaxBytes: 2000,
 		},
+		start: time.Now(),
 	}
 }
 
@@ -54,7 +69,9 @@ func (r *StatWriter) Write(b []byte) (int, error) {
 	if r.interceptBody && IsStatusError(r.statusCode) {
 		// Buffer for logging; ignore ErrBufferLimit since partial content is acceptable for logging
 		_, _ = r.buf.Write(b)
+		r.bytesWritten += int64(len(b))
 		if r.teeOnErr {
+			r.forwarded()
 			n, err := r.ResponseWriter.Write(b)
 			if n > 0 {
 				r.bodyForwarded = true
@@ -63,7 +80,75 @@ func (r *StatWriter) Write(b []byte) (int, error) {
 		}
 		return len(b), nil
 	}
-	return r.ResponseWriter.Write(b)
+	r.forwarded()
+	n, err := r.ResponseWriter.Write(b)
+	r.bytesWritten += int64(n)
+	if r.respBody != nil && n > 0 {
+		_, _ = r.respBody.Write(b[:n])
+	}
+	return n, err
+}
+
+// ReadFrom keeps the io.ReaderFrom fast path of the underlying writer (e.g. sendfile for
+// http.ServeFile) when the body is neither intercepted nor captured.
+func (r *StatWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := r.ResponseWriter.(io.ReaderFrom)
+	if !ok || r.respBody != nil || (r.interceptBody && IsStatusError(r.statusCode)) {
+		return io.Copy(writerOnly{r}, src)
+	}
+	r.forwarded()
+	n, err := rf.ReadFrom(src)
+	r.bytesWritten += n
+	return n, err
+}
+
+// writerOnly hides the ReadFrom method, so io.Copy does not call it again
+type writerOnly struct {
+	io.Writer
+}
+
+// forwarded records that the response reached the underlying writer, the first time sets the TTFB
+func (r *StatWriter) forwarded() {
+	r.headerWritten = true
+	if r.ttfb == 0 {
+		r.ttfb = time.Since(r.start)
+	}
+}
+
+// BytesWritten returns the response body bytes written by the handler
+func (r *StatWriter) BytesWritten() int64 {
+	return r.bytesWritten
+}
+
+// BytesRead returns the request body bytes read by the handler, the body is only counted
+// if it was wrapped with CountBody.
+func (r *StatWriter) BytesRead() int64 {
+	return r.bytesRead.Load()
+}
+
+// TTFB returns the time from the creation of the writer until the response was first sent
+// to the client, or 0 if nothing was sent yet.
+func (r *StatWriter) TTFB() time.Duration {
+	return r.ttfb
+}
+
+// CountBody wraps the request body to count the bytes read, see BytesRead
+func (r *StatWriter) CountBody(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	req.Body = &countingBody{ReadCloser: req.Body, n: &r.bytesRead}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingBody) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.n.Add(int64(n))
+	return n, err
 }
 
 // BodyForwarded returns true if the response body was already written to the client
@@ -84,16 +169,16 @@ func (r *StatWriter) WriteHeader(code int) {
 		// Defer: middleware will write headers after determining the final body.
 		return
 	}
+	r.forwarded()
 	r.ResponseWriter.WriteHeader(code)
-	r.headerWritten = true
 }
 
 // flushHeader ensures the status code is written to the underlying ResponseWriter.
 // Called by the middleware after it has set final headers.
 func (r *StatWriter) flushHeader() {
 	if !r.headerWritten {
+		r.forwarded()
 		r.ResponseWriter.WriteHeader(r.statusCode)
-		r.headerWritten = true
 	}
 }
 
@@ -103,6 +188,58 @@ func (r *StatWriter) Unwrap() http.ResponseWriter {
 	return r.ResponseWriter
 }
 
+// Hijack takes over the connection, e.g. for WebSockets, it is also used by http.ResponseController.
+// The status is recorded as 101 Switching Protocols and the bytes sent over the returned
+// connection are counted, see HijackedBytes.
+func (r *StatWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.hijacked = true
+	r.headerWritten = true
+	r.statusCode = http.StatusSwitchingProtocols
+
+	cc := &countingConn{Conn: conn, read: &r.hijackRead, written: &r.hijackWritten}
+	// keep the data already buffered by the server
+	var reader io.Reader = cc
+	if n := brw.Reader.Buffered(); n > 0 {
+		buffered, _ := brw.Reader.Peek(n)
+		reader = io.MultiReader(bytes.NewReader(bytes.Clone(buffered)), cc)
+		r.hijackRead.Add(int64(n))
+	}
+	return cc, bufio.NewReadWriter(bufio.NewReader(reader), bufio.NewWriter(cc)), nil
+}
+
+// Hijacked returns true if the handler took over the connection
+func (r *StatWriter) Hijacked() bool {
+	return r.hijacked
+}
+
+// HijackedBytes returns the bytes read from and written to a hijacked connection
+func (r *StatWriter) HijackedBytes() (read, written int64) {
+	return r.hijackRead.Load(), r.hijackWritten.Load()
+}
+
+// countingConn counts the bytes transferred over a hijacked connection
+type countingConn struct {
+	net.Conn
+	read    *atomic.Int64
+	written *atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
 func IsStatusError(statusCode int) bool {
 	return statusCode >= 400
 }
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -158,3 +160,96 @@ func TestStatWriter_StatusCodeStr(t *testing.T) {
 		t.Errorf("expected '418', got %q", sw.StatusCodeStr())
 	}
 }
+
+func TestStatWriter_Hijack(t *testing.T) {
+	var sw *StatWriter
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw = NewWriter(w, true, false)
+		// hijack through the ResponseController, as e.g. httputil.ReverseProxy does
+		conn, brw, err := http.NewResponseController(sw).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		_ = brw.Flush()
+		buf := make([]byte, 4)
+		_, _ = io.ReadFull(brw, buf)
+		_, _ = conn.Write(buf)
+		sw.flushHeader() // must not write on the hijacked connection
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\nping"))
+	got, _ := io.ReadAll(conn)
+	_ = conn.Close()
+
+	if string(got) != "HTTP/1.1 101 Switching Protocols\r\n\r\nping" {
+		t.Errorf("unexpected response %q", got)
+	}
+	if !sw.Hijacked() || sw.StatusCode() != http.StatusSwitchingProtocols {
+		t.Errorf("expected hijacked connection with status 101, got %v %d", sw.Hijacked(), sw.StatusCode())
+	}
+	read, written := sw.HijackedBytes()
+	if read != 4 || written != int64(len(got)) {
+		t.Errorf("expected 4 bytes read and %d written, got %d and %d", len(got), read, written)
+	}
+}
+
+// readerFromRecorder records if the ReadFrom fast path was used
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestStatWriter_BytesAndTTFB(t *testing.T) {
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	sw := NewWriter(rec, true, false)
+	req := httptest.NewRequest("POST", "/", strings.NewReader("request body"))
+	sw.CountBody(req)
+
+	if sw.TTFB() != 0 {
+		t.Errorf("expected no TTFB before writing")
+	}
+	_, _ = io.ReadAll(req.Body)
+	_, _ = sw.Write([]byte("abc"))
+	// strings.Reader implements io.WriterTo, which io.Copy would prefer
+	_, _ = io.Copy(sw, io.LimitReader(strings.NewReader("defgh"), 100))
+
+	if !rec.readFrom {
+		t.Error("expected the ReadFrom fast path to be used")
+	}
+	if sw.BytesRead() != 12 || sw.BytesWritten() != 8 || rec.Body.String() != "abcdefgh" {
+		t.Errorf("expected 12 bytes read and 8 written, got %d, %d %q", sw.BytesRead(), sw.BytesWritten(), rec.Body.String())
+	}
+	if sw.TTFB() <= 0 {
+		t.Errorf("expected TTFB to be measured")
+	}
+}
+
+func TestStatWriter_ReadFromIntercepted(t *testing.T) {
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	sw := NewWriter(rec, true, false)
+	sw.WriteHeader(http.StatusBadRequest)
+	_, _ = io.Copy(sw, strings.NewReader("bad input"))
+
+	if rec.readFrom || rec.Body.Len() != 0 {
+		t.Errorf("expected error body to be intercepted, got %q", rec.Body.String())
+	}
+	if sw.buf.String() != "bad input" || sw.BytesWritten() != 9 {
+		t.Errorf("expected body in buffer, got %q", sw.buf.String())
+	}
+	if sw.TTFB() != 0 {
+		t.Errorf("expected no TTFB while the response is deferred")
+	}
+}
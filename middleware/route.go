@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+)
+
+type routeKey struct{}
+
+// RouteUnmatched is the route name of requests a router could not match, e.g. 404 and 405 responses,
+// so unknown paths share a single metric label
+const RouteUnmatched = "unmatched"
+
+// routeSlot lets middleware running before the router read the route name once the request
+// has been handled, the same way auth.Track does for the principal.
+type routeSlot struct {
+	mu   sync.Mutex
+	name string
+}
+
+// trackRoute returns a copy of ctx in which the route set by an inner router becomes visible
+func trackRoute(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(routeKey{}).(*routeSlot); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, routeKey{}, &routeSlot{})
+}
+
+// WithRoute names the route of the request, e.g. "GET /users/{id}". Logging, Middleware and
+// Metrics log it as route and use it as addr label instead of the path, which keeps the metric
+// cardinality low. It is set by router.Router.
+func WithRoute(ctx context.Context, name string) context.Context {
+	if s, ok := ctx.Value(routeKey{}).(*routeSlot); ok {
+		s.mu.Lock()
+		s.name = name
+		s.mu.Unlock()
+		return ctx
+	}
+	return context.WithValue(ctx, routeKey{}, &routeSlot{name: name})
+}
+
+// RouteFromContext returns the route name set with WithRoute, or an empty string
+func RouteFromContext(ctx context.Context) string {
+	if s, ok := ctx.Value(routeKey{}).(*routeSlot); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.name
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	tcs := []struct {
+		name   string
+		cfg    middleware.SecurityHeadersCfg
+		expect map[string]string
+	}{
+		{
+			name: "api preset",
+			cfg:  middleware.APISecurityHeaders(),
+			expect: map[string]string{
+				"Strict-Transport-Security":    "max-age=31536000; includeSubDomains",
+				"X-Content-Type-Options":       "nosniff",
+				"X-Frame-Options":              "DENY",
+				"Referrer-Policy":              "no-referrer",
+				"Cross-Origin-Resource-Policy": "same-origin",
+				"Content-Security-Policy":      "default-src 'none'; frame-ancestors 'none'",
+			},
+		},
+		{
+			name: "report only",
+			cfg: middleware.SecurityHeadersCfg{
+				CSP:           middleware.NewCSP().DefaultSrc(middleware.CSPSelf).ReportURI("/csp"),
+				CSPReportOnly: true,
+			},
+			expect: map[string]string{
+				"Content-Security-Policy-Report-Only": "default-src 'self'; report-uri /csp",
+				"Content-Security-Policy":             "",
+				"Strict-Transport-Security":           "",
+			},
+		},
+		{
+			name: "hsts preload",
+			cfg: middleware.SecurityHeadersCfg{
+				HSTS: middleware.HSTS{MaxAge: time.Hour, Preload: true},
+			},
+			expect: map[string]string{
+				"Strict-Transport-Security": "max-age=3600; preload",
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := middleware.SecurityHeaders(tc.cfg)(testHandler(200, "ok"))
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			handler.ServeHTTP(rec, req)
+
+			for k, want := range tc.expect {
+				if diff := cmp.Diff(rec.Header().Get(k), want); diff != "" {
+					t.Errorf("header %s: unexpected value (-got +want)\n%s", k, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders_HandlerOverride(t *testing.T) {
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	})
+	handler := middleware.SecurityHeaders(middleware.APISecurityHeaders())(th)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("expected handler to override header, got %q", got)
+	}
+}
+
+func TestCSPBuilder(t *testing.T) {
+	csp := middleware.NewCSP().
+		DefaultSrc(middleware.CSPSelf).
+		ScriptSrc(middleware.CSPSelf).
+		ImgSrc("data:").
+		ScriptSrc("https://cdn.example.com").
+		UpgradeInsecureRequests()
+
+	want := "default-src 'self'; script-src 'self' https://cdn.example.com; img-src data:; upgrade-insecure-requests"
+	if diff := cmp.Diff(csp.String(), want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	tcs := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		expectCode  int
+		expectLog   string
+	}{
+		{
+			name:        "legacy report",
+			method:      "POST",
+			contentType: "application/csp-report",
+			body:        `{"csp-report":{"document-uri":"https://a.com/","blocked-uri":"https://evil.com/x.js","violated-directive":"script-src"}}`,
+			expectCode:  http.StatusNoContent,
+			expectLog:   "blocked-uri=https://evil.com/x.js violated-directive=script-src",
+		},
+		{
+			name:        "reporting api",
+			method:      "POST",
+			contentType: "application/reports+json",
+			body:        `[{"type":"csp-violation","body":{"documentURL":"https://a.com/","blockedURL":"inline","effectiveDirective":"style-src"}}]`,
+			expectCode:  http.StatusNoContent,
+			expectLog:   "blocked-uri=inline violated-directive=style-src",
+		},
+		{
+			name:        "malformed",
+			method:      "POST",
+			contentType: "application/csp-report",
+			body:        `not json`,
+			expectCode:  http.StatusBadRequest,
+		},
+		{
+			name:       "wrong method",
+			method:     "GET",
+			expectCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, logger := newMemSlog()
+			handler := middleware.CSPReportHandler(logger)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "/csp", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected status %d, got %d", tc.expectCode, rec.Code)
+			}
+			if tc.expectLog != "" && !strings.Contains(buf.String(), tc.expectLog) {
+				t.Errorf("expected log to contain %q, got %q", tc.expectLog, buf.String())
+			}
+		})
+	}
+}
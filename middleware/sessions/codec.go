@@ -0,0 +1,87 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// codec protects the cookie value of client side sessions. With an encryption key the value
+// is encrypted and authenticated with AES-GCM, otherwise it is signed with HMAC-SHA256 and
+// readable by the client.
+type codec struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+func newCodec(hashKey, encryptionKey []byte) (*codec, error) {
+	c := &codec{hashKey: hashKey}
+	if len(encryptionKey) > 0 {
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aead = aead
+		return c, nil
+	}
+	if len(hashKey) < 32 {
+		return nil, errors.New("hash key needs to be at least 32 bytes")
+	}
+	return c, nil
+}
+
+// encode binds the value to the cookie name, so a value can't be replayed in another cookie
+func (c *codec) encode(name string, value []byte) (string, error) {
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		out := c.aead.Seal(nonce, nonce, value, []byte(name))
+		return base64.RawURLEncoding.EncodeToString(out), nil
+	}
+	payload := base64.RawURLEncoding.EncodeToString(value)
+	return payload + "." + c.sign(name, payload), nil
+}
+
+func (c *codec) decode(name, cookie string) ([]byte, error) {
+	if c.aead != nil {
+		raw, err := base64.RawURLEncoding.DecodeString(cookie)
+		if err != nil || len(raw) < c.aead.NonceSize() {
+			return nil, errInvalidCookie
+		}
+		nonce, ciphertext := raw[:c.aead.NonceSize()], raw[c.aead.NonceSize():]
+		value, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			return nil, errInvalidCookie
+		}
+		return value, nil
+	}
+	payload, sig, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(c.sign(name, payload))) {
+		return nil, errInvalidCookie
+	}
+	value, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	return value, nil
+}
+
+func (c *codec) sign(name, payload string) string {
+	mac := hmac.New(sha256.New, c.hashKey)
+	_, _ = mac.Write([]byte(name + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,234 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// maxCookieSize is the size limit most browsers apply to a single cookie
+const maxCookieSize = 4096
+
+// Cfg configures the session Manager. Without a Store the whole session is kept in the cookie,
+// which then needs a HashKey or an EncryptionKey.
+type Cfg struct {
+	Store Store
+	// HashKey signs client side sessions, it needs to be at least 32 bytes
+	HashKey []byte
+	// EncryptionKey (16, 24 or 32 bytes) encrypts client side sessions with AES-GCM, it takes
+	// precedence over HashKey
+	EncryptionKey []byte
+
+	CookieName string // defaults to session
+	Path       string // defaults to /
+	Domain     string
+	// InsecureCookie drops the Secure attribute, only use it for local development
+	InsecureCookie bool
+	SameSite       http.SameSite // defaults to Lax
+
+	// IdleTimeout expires a session that was not used for this duration, default 30 minutes
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires a session this long after it was created regardless of activity,
+	// default 12 hours
+	AbsoluteTimeout time.Duration
+
+	Logger *slog.Logger // used to log store errors
+}
+
+// Manager loads and saves sessions for every request passing through its Middleware
+type Manager struct {
+	cfg   Cfg
+	codec *codec
+}
+
+func New(cfg Cfg) (*Manager, error) {
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.SameSite == 0 {
+		cfg.SameSite = http.SameSiteLaxMode
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = 30 * time.Minute
+	}
+	if cfg.AbsoluteTimeout == 0 {
+		cfg.AbsoluteTimeout = 12 * time.Hour
+	}
+	m := &Manager{cfg: cfg}
+	if cfg.Store == nil {
+		c, err := newCodec(cfg.HashKey, cfg.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		m.codec = c
+	}
+	return m, nil
+}
+
+// Middleware loads the session of the request into the context and saves it if it was modified.
+// The session is saved before the response headers are written, so that the cookie is part of
+// the response even when the handler streams its body.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.load(r)
+		sw := &sessionWriter{ResponseWriter: w, save: func() { m.save(w, r, s) }}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, s)))
+		sw.saveOnce()
+	})
+}
+
+// SessionID returns the id of the request session, it can be used as CSRFCfg.SessionID
+func (m *Manager) SessionID(r *http.Request) string {
+	if s := FromContext(r.Context()); s != nil {
+		return s.ID()
+	}
+	return ""
+}
+
+func (m *Manager) load(r *http.Request) *Session {
+	now := time.Now()
+	c, err := r.Cookie(m.cfg.CookieName)
+	if err != nil {
+		return newSession(now)
+	}
+
+	var raw []byte
+	if m.cfg.Store != nil {
+		raw, err = m.cfg.Store.Get(r.Context(), c.Value)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			m.logErr(r, "loading session", err)
+		}
+	} else {
+		raw, err = m.codec.decode(m.cfg.CookieName, c.Value)
+	}
+	if err != nil {
+		return newSession(now)
+	}
+
+	s := &Session{}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return newSession(now)
+	}
+	if m.cfg.Store != nil && s.data.ID != c.Value {
+		return newSession(now)
+	}
+	if now.Sub(s.data.LastSeen) > m.cfg.IdleTimeout || now.Sub(s.data.Created) > m.cfg.AbsoluteTimeout {
+		if m.cfg.Store != nil {
+			_ = m.cfg.Store.Delete(r.Context(), s.data.ID)
+		}
+		return newSession(now)
+	}
+	// refresh the idle timeout without writing the session on every single request
+	if now.Sub(s.data.LastSeen) > m.cfg.IdleTimeout/10 {
+		s.data.LastSeen = now
+		s.dirty = true
+	}
+	return s
+}
+
+func (m *Manager) save(w http.ResponseWriter, r *http.Request, s *Session) {
+	if !s.dirty {
+		return
+	}
+	ctx := r.Context()
+	if s.destroyed {
+		if m.cfg.Store != nil && !s.isNew {
+			if err := m.cfg.Store.Delete(ctx, s.data.ID); err != nil {
+				m.logErr(r, "deleting session", err)
+			}
+		}
+		m.setCookie(w, "", -1)
+		return
+	}
+	if m.cfg.Store != nil && s.oldID != "" {
+		if err := m.cfg.Store.Delete(ctx, s.oldID); err != nil {
+			m.logErr(r, "deleting rotated session", err)
+		}
+	}
+
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		m.logErr(r, "encoding session", err)
+		return
+	}
+	expires := s.data.Created.Add(m.cfg.AbsoluteTimeout)
+	value := s.data.ID
+	if m.cfg.Store != nil {
+		if err := m.cfg.Store.Set(ctx, s.data.ID, raw, expires); err != nil {
+			m.logErr(r, "saving session", err)
+			return
+		}
+	} else {
+		value, err = m.codec.encode(m.cfg.CookieName, raw)
+		if err != nil {
+			m.logErr(r, "encoding session", err)
+			return
+		}
+		if len(value) > maxCookieSize {
+			m.logErr(r, "saving session", errors.New("session too large for a cookie, use a server side store"))
+			return
+		}
+	}
+	m.setCookie(w, value, int(time.Until(expires).Seconds()))
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cfg.CookieName,
+		Value:    value,
+		Path:     m.cfg.Path,
+		Domain:   m.cfg.Domain,
+		MaxAge:   maxAge,
+		Secure:   !m.cfg.InsecureCookie,
+		HttpOnly: true,
+		SameSite: m.cfg.SameSite,
+	})
+}
+
+func (m *Manager) logErr(r *http.Request, msg string, err error) {
+	if m.cfg.Logger != nil {
+		m.cfg.Logger.ErrorContext(r.Context(), msg, slog.Any("err", err), slog.String("url", r.RequestURI))
+	}
+}
+
+// sessionWriter saves the session right before the headers are written
+type sessionWriter struct {
+	http.ResponseWriter
+	save  func()
+	saved bool
+}
+
+func (sw *sessionWriter) saveOnce() {
+	if !sw.saved {
+		sw.saved = true
+		sw.save()
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	sw.saveOnce()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.saveOnce()
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *sessionWriter) Flush() {
+	sw.saveOnce()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
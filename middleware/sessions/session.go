@@ -0,0 +1,120 @@
+// Package sessions provides cookie based and server side sessions as an HTTP middleware.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
+// Session holds the values of a user session, it is obtained in a handler with FromContext.
+// Values are serialized as JSON, so numbers read back from a stored session are float64.
+// A Session is not safe for concurrent use, the same as the request it belongs to.
+type Session struct {
+	data      data
+	isNew     bool
+	dirty     bool
+	destroyed bool
+	oldID     string // set on rotation, the previous id is removed from the store
+}
+
+// data is the serialized form of a session
+type data struct {
+	ID       string         `json:"id"`
+	Values   map[string]any `json:"v,omitempty"`
+	Flashes  []string       `json:"f,omitempty"`
+	Created  time.Time      `json:"c"`
+	LastSeen time.Time      `json:"l"`
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{
+		data: data{
+			ID:       newID(),
+			Values:   map[string]any{},
+			Created:  now,
+			LastSeen: now,
+		},
+		isNew: true,
+	}
+}
+
+// ID returns the session identifier, it changes when the session is rotated
+func (s *Session) ID() string { return s.data.ID }
+
+// IsNew is true if the session was created during the current request
+func (s *Session) IsNew() bool { return s.isNew }
+
+func (s *Session) Get(key string) any {
+	return s.data.Values[key]
+}
+
+// GetString returns the value of key if it is a string
+func (s *Session) GetString(key string) string {
+	v, _ := s.data.Values[key].(string)
+	return v
+}
+
+func (s *Session) Set(key string, value any) {
+	if s.data.Values == nil {
+		s.data.Values = map[string]any{}
+	}
+	s.data.Values[key] = value
+	s.dirty = true
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.dirty = true
+	}
+}
+
+// AddFlash adds a message that is kept until it is read with Flashes, e.g. to show a
+// notification after a redirect.
+func (s *Session) AddFlash(msg string) {
+	s.data.Flashes = append(s.data.Flashes, msg)
+	s.dirty = true
+}
+
+// Flashes returns and removes all flash messages
+func (s *Session) Flashes() []string {
+	f := s.data.Flashes
+	if len(f) > 0 {
+		s.data.Flashes = nil
+		s.dirty = true
+	}
+	return f
+}
+
+// Rotate assigns a new id to the session while keeping its values, call it whenever the
+// privilege level changes (login, logout, role change) to prevent session fixation. The creation
+// time is kept, so rotating does not extend the absolute timeout.
+func (s *Session) Rotate() {
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.data.ID
+	}
+	s.data.ID = newID()
+	s.dirty = true
+}
+
+// Destroy removes the session from the store and expires the cookie
+func (s *Session) Destroy() {
+	s.destroyed = true
+	s.dirty = true
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type ctxKey struct{}
+
+// FromContext returns the session of the request, it is nil outside the sessions middleware
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(ctxKey{}).(*Session)
+	return s
+}
@@ -0,0 +1,210 @@
+package sessions_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+	"github.com/go-bumbu/http/middleware/sessions"
+)
+
+// sessionHandler exposes a few session operations based on the request path
+func sessionHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, r *http.Request) {
+		s := sessions.FromContext(r.Context())
+		s.Set("user", r.URL.Query().Get("user"))
+		s.AddFlash("welcome")
+	})
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		s := sessions.FromContext(r.Context())
+		_, _ = fmt.Fprintf(w, "%s %v", s.GetString("user"), s.Flashes())
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s := sessions.FromContext(r.Context())
+		s.Rotate()
+		s.Set("role", "admin")
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		sessions.FromContext(r.Context()).Destroy()
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		sessions.FromContext(r.Context()).Set("user", "bob")
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	return mux
+}
+
+// client keeps the cookie between requests
+type client struct {
+	t      *testing.T
+	h      http.Handler
+	cookie *http.Cookie
+}
+
+func (c *client) do(path string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	if c.cookie != nil {
+		req.AddCookie(c.cookie)
+	}
+	rec := httptest.NewRecorder()
+	c.h.ServeHTTP(rec, req)
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == "session" {
+			c.cookie = ck
+			if ck.MaxAge < 0 {
+				c.cookie = nil
+			}
+		}
+	}
+	return rec
+}
+
+func newManagers(t *testing.T) map[string]*sessions.Manager {
+	fileStore, err := sessions.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgs := map[string]sessions.Cfg{
+		"signed cookie":    {HashKey: []byte(strings.Repeat("k", 32))},
+		"encrypted cookie": {EncryptionKey: []byte(strings.Repeat("e", 32))},
+		"memory store":     {Store: sessions.NewMemoryStore()},
+		"file store":       {Store: fileStore},
+	}
+	out := map[string]*sessions.Manager{}
+	for name, cfg := range cfgs {
+		m, err := sessions.New(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		out[name] = m
+	}
+	return out
+}
+
+func TestSessions(t *testing.T) {
+	for name, m := range newManagers(t) {
+		t.Run(name, func(t *testing.T) {
+			c := &client{t: t, h: m.Middleware(sessionHandler())}
+
+			c.do("/set?user=alice")
+			if c.cookie == nil {
+				t.Fatal("expected session cookie")
+			}
+			if !c.cookie.HttpOnly || !c.cookie.Secure || c.cookie.SameSite != http.SameSiteLaxMode {
+				t.Errorf("unexpected cookie attributes: %+v", c.cookie)
+			}
+
+			if got := c.do("/get").Body.String(); got != "alice [welcome]" {
+				t.Errorf("unexpected body %q", got)
+			}
+			// flashes are consumed
+			if got := c.do("/get").Body.String(); got != "alice []" {
+				t.Errorf("unexpected body %q", got)
+			}
+
+			before := c.cookie.Value
+			c.do("/login")
+			if c.cookie.Value == before {
+				t.Error("expected session to be rotated")
+			}
+			if got := c.do("/get").Body.String(); got != "alice []" {
+				t.Errorf("expected values to survive rotation, got %q", got)
+			}
+
+			c.do("/logout")
+			if got := c.do("/get").Body.String(); got != " []" {
+				t.Errorf("expected empty session after logout, got %q", got)
+			}
+		})
+	}
+}
+
+func TestSessions_RotatedIDIsRevoked(t *testing.T) {
+	m, _ := sessions.New(sessions.Cfg{Store: sessions.NewMemoryStore()})
+	c := &client{t: t, h: m.Middleware(sessionHandler())}
+	c.do("/set?user=alice")
+	old := c.cookie
+	c.do("/login")
+
+	attacker := &client{t: t, h: c.h, cookie: old}
+	if got := attacker.do("/get").Body.String(); got != " []" {
+		t.Errorf("expected the pre-rotation id to be invalid, got %q", got)
+	}
+}
+
+func TestSessions_TamperedCookie(t *testing.T) {
+	m, _ := sessions.New(sessions.Cfg{HashKey: []byte(strings.Repeat("k", 32))})
+	c := &client{t: t, h: m.Middleware(sessionHandler())}
+	c.do("/set?user=alice")
+	c.cookie.Value = "x" + c.cookie.Value
+	if got := c.do("/get").Body.String(); got != " []" {
+		t.Errorf("expected tampered cookie to be ignored, got %q", got)
+	}
+}
+
+func TestSessions_Timeouts(t *testing.T) {
+	m, _ := sessions.New(sessions.Cfg{
+		Store:       sessions.NewMemoryStore(),
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	c := &client{t: t, h: m.Middleware(sessionHandler())}
+	c.do("/set?user=alice")
+	time.Sleep(30 * time.Millisecond)
+	if got := c.do("/get").Body.String(); got != " []" {
+		t.Errorf("expected idle session to expire, got %q", got)
+	}
+
+	m, _ = sessions.New(sessions.Cfg{
+		Store:           sessions.NewMemoryStore(),
+		AbsoluteTimeout: 20 * time.Millisecond,
+	})
+	c = &client{t: t, h: m.Middleware(sessionHandler())}
+	c.do("/set?user=alice")
+	time.Sleep(30 * time.Millisecond)
+	if got := c.do("/get").Body.String(); got != " []" {
+		t.Errorf("expected session to reach absolute timeout, got %q", got)
+	}
+
+	// rotating the id does not extend the absolute timeout
+	m, _ = sessions.New(sessions.Cfg{
+		Store:           sessions.NewMemoryStore(),
+		AbsoluteTimeout: 50 * time.Millisecond,
+	})
+	c = &client{t: t, h: m.Middleware(sessionHandler())}
+	c.do("/set?user=alice")
+	time.Sleep(30 * time.Millisecond)
+	c.do("/login")
+	time.Sleep(30 * time.Millisecond)
+	if got := c.do("/get").Body.String(); got != " []" {
+		t.Errorf("expected rotated session to reach absolute timeout, got %q", got)
+	}
+}
+
+func TestSessions_CookieBeforeStatWriterFlush(t *testing.T) {
+	m, _ := sessions.New(sessions.Cfg{Store: sessions.NewMemoryStore()})
+	mw := middleware.New(middleware.Cfg{JsonErrors: true})
+	c := &client{t: t, h: mw.Middleware(m.Middleware(sessionHandler()))}
+
+	rec := c.do("/fail")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rec.Code)
+	}
+	if c.cookie == nil {
+		t.Error("expected session cookie on an error response rewritten by the middleware")
+	}
+}
+
+func TestNew_InvalidKeys(t *testing.T) {
+	if _, err := sessions.New(sessions.Cfg{HashKey: []byte("short")}); err == nil {
+		t.Error("expected error for short hash key")
+	}
+	if _, err := sessions.New(sessions.Cfg{EncryptionKey: []byte("bad")}); err == nil {
+		t.Error("expected error for invalid encryption key")
+	}
+}
@@ -0,0 +1,184 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when the session does not exist or has expired
+var ErrNotFound = errors.New("session not found")
+
+// Store persists server side sessions, the cookie only carries the session id.
+type Store interface {
+	Get(ctx context.Context, id string) ([]byte, error)
+	Set(ctx context.Context, id string, value []byte, expires time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryStore keeps sessions in memory, sessions are lost on restart and not shared between
+// instances; expired sessions are removed periodically while new ones are stored.
+type MemoryStore struct {
+	mu        sync.Mutex
+	items     map[string]memItem
+	lastSweep time.Time
+}
+
+type memItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: map[string]memItem{}}
+}
+
+func (m *MemoryStore) Get(_ context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, ok := m.items[id]
+	if !ok || time.Now().After(it.expires) {
+		delete(m.items, id)
+		return nil, ErrNotFound
+	}
+	return it.value, nil
+}
+
+func (m *MemoryStore) Set(_ context.Context, id string, value []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, it := range m.items {
+			if now.After(it.expires) {
+				delete(m.items, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.items[id] = memItem{value: value, expires: expires}
+	return nil
+}
+
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, id)
+	return nil
+}
+
+// FileStore keeps every session in a file inside a directory, which allows sessions to
+// survive restarts of a single instance.
+type FileStore struct {
+	dir string
+}
+
+// validID prevents path traversal through crafted session cookies
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// NewFileStore creates the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating session dir: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+type fileItem struct {
+	Expires time.Time `json:"expires"`
+	Value   []byte    `json:"value"`
+}
+
+func (f *FileStore) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileStore) Get(_ context.Context, id string) ([]byte, error) {
+	p, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p) //nolint:gosec // the file name is validated by path
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading session: %w", err)
+	}
+	var it fileItem
+	if err := json.Unmarshal(b, &it); err != nil {
+		return nil, fmt.Errorf("decoding session file: %w", err)
+	}
+	if time.Now().After(it.Expires) {
+		_ = os.Remove(p)
+		return nil, ErrNotFound
+	}
+	return it.Value, nil
+}
+
+func (f *FileStore) Set(_ context.Context, id string, value []byte, expires time.Time) error {
+	p, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(fileItem{Expires: expires, Value: value})
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename to not expose partially written sessions
+	tmp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing session: %w", err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing session: %w", err)
+	}
+	return nil
+}
+
+func (f *FileStore) Delete(_ context.Context, id string) error {
+	p, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("deleting session: %w", err)
+	}
+	return nil
+}
+
+// Cleanup removes the files of expired sessions, call it periodically.
+func (f *FileStore) Cleanup() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return fmt.Errorf("reading session dir: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		// Get removes expired files
+		_, _ = f.Get(context.Background(), name[:len(name)-len(".json")])
+	}
+	return nil
+}
@@ -4,51 +4,96 @@ import (
 	"log/slog"
 	"net/http"
 	"time"
+
+	"github.com/go-bumbu/http/middleware/auth"
 )
 
 // Logging returns a standalone middleware that logs requests using structured logging.
 // Error responses (>= 400) include the response body in the log.
 func Logging(logger *slog.Logger) func(http.Handler) http.Handler {
+	return logging(logger, nil)
+}
+
+// LoggingWithRules is Logging with rules to exclude, sample and re-level requests, see LogRules
+func LoggingWithRules(logger *slog.Logger, rules LogRules) func(http.Handler) http.Handler {
+	return logging(logger, newLogRules(rules))
+}
+
+func logging(logger *slog.Logger, rules *logRules) func(http.Handler) http.Handler {
 	if logger == nil {
 		return func(next http.Handler) http.Handler { return next }
 	}
-	m := &Middleware{logger: logger}
+	m := &Middleware{logger: logger, logRules: rules}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			timeStart := time.Now()
 			respWriter := NewWriter(w, true, true)
+			r = r.WithContext(trackRoute(auth.Track(r.Context())))
+			r = withLogEntry(r, logger, m.logRules.redactor())
+			respWriter.CountBody(r)
+			m.logRules.capture(r, respWriter)
 
 			next.ServeHTTP(respWriter, r)
 			timeDiff := time.Since(timeStart)
 
 			errMsg := m.getErrMsg(respWriter.statusCode, respWriter.buf)
-			m.log(r, respWriter.StatusCode(), errMsg, timeDiff)
+			m.log(r, respWriter, errMsg, timeDiff)
 
 			respWriter.flushHeader()
 		})
 	}
 }
 
-func (c *Middleware) log(r *http.Request, statusCode int, errmsg string, dur time.Duration) {
+func (c *Middleware) log(r *http.Request, respWriter *StatWriter, errmsg string, dur time.Duration) {
 	if c.logger == nil {
 		return
 	}
+	statusCode := respWriter.StatusCode()
+	level, ok := c.logRules.level(r, statusCode, dur)
+	if !ok {
+		return
+	}
+
+	rd := c.logRules.redactor()
 
 	attrs := []slog.Attr{
 		slog.String("method", r.Method),
-		slog.String("url", r.RequestURI),
+		slog.String("url", redactURL(rd, r)),
 		slog.Duration("req-dur", dur),
 		slog.Int("response-code", statusCode),
 		slog.String("ip", userIp(r)),
-		slog.String("req-id", r.Header.Get("Request-Id")),
+		slog.String("req-id", r.Header.Get(HeaderRequestID)),
+	}
+	if route := RouteFromContext(r.Context()); route != "" {
+		attrs = append(attrs, slog.String("route", route))
+	}
+	if p, ok := auth.FromContext(r.Context()); ok {
+		attrs = append(attrs, slog.String("principal", p.Name))
+	}
+	if h := redactHeaders(rd, r.Header); len(h) > 0 {
+		attrs = append(attrs, slog.Group("req-header", h...))
+	}
+	if h := redactHeaders(rd, respWriter.Header()); len(h) > 0 {
+		attrs = append(attrs, slog.Group("resp-header", h...))
 	}
 	if IsStatusError(statusCode) {
-		attrs = append(attrs, slog.String("err-handlerMsg", errmsg))
+		if rd != nil {
+			errmsg = rd.RedactBody(errmsg)
+		}
+		attrs = append(attrs, slog.String("err-handlerMsg", truncMarker(errmsg, respWriter.buf)))
 	}
-
-	level := slog.LevelInfo
-	if IsServerErr(statusCode) {
-		level = slog.LevelError
+	attrs = append(attrs, logAttrs(r.Context())...)
+	attrs = append(attrs, c.logRules.bodyAttrs(respWriter)...)
+	if respWriter.Hijacked() {
+		// req-dur covers the whole lifetime of the connection
+		read, written := respWriter.HijackedBytes()
+		attrs = append(attrs, slog.Bool("hijacked", true), slog.Int64("bytes-read", read), slog.Int64("bytes-written", written))
+	} else {
+		attrs = append(attrs,
+			slog.Int64("bytes-read", respWriter.BytesRead()),
+			slog.Int64("bytes-written", respWriter.BytesWritten()),
+			slog.Duration("ttfb", respWriter.TTFB()),
+		)
 	}
 
 	c.logger.LogAttrs(r.Context(), level, "", attrs...)
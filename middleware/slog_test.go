@@ -5,11 +5,15 @@ import (
 	"context"
 	"io"
 	"log/slog"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/go-bumbu/http/middleware"
+	"github.com/go-bumbu/http/middleware/auth"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -26,21 +30,21 @@ func TestSlogMiddleware(t *testing.T) {
 			name:          "regular request",
 			statusCode:    200,
 			handlerMsg:    "ok",
-			expect:        "INFO method=GET url=/metrics response-code=200 req-id= ",
+			expect:        "INFO method=GET url=/metrics response-code=200 req-id= bytes-read=0 bytes-written=2 ",
 			expectPayload: "ok",
 		},
 		{
 			name:          "capture 4xx handlerMsg",
 			statusCode:    401,
 			handlerMsg:    "unauthorized",
-			expect:        "INFO method=GET url=/metrics response-code=401 req-id= err-handlerMsg=unauthorized ",
+			expect:        "INFO method=GET url=/metrics response-code=401 req-id= err-handlerMsg=unauthorized bytes-read=0 bytes-written=12 ",
 			expectPayload: "unauthorized",
 		},
 		{
 			name:          "capture error handlerMsg",
 			statusCode:    500,
 			handlerMsg:    "my db broke down",
-			expect:        "ERROR method=GET url=/metrics response-code=500 req-id= err-handlerMsg=my db broke down ",
+			expect:        "ERROR method=GET url=/metrics response-code=500 req-id= err-handlerMsg=my db broke down bytes-read=0 bytes-written=16 ",
 			expectPayload: "my db broke down",
 		},
 		{
@@ -48,7 +52,7 @@ func TestSlogMiddleware(t *testing.T) {
 			statusCode:    500,
 			genericErr:    true,
 			handlerMsg:    "my db broke down",
-			expect:        "ERROR method=GET url=/metrics response-code=500 req-id= err-handlerMsg=my db broke down ",
+			expect:        "ERROR method=GET url=/metrics response-code=500 req-id= err-handlerMsg=my db broke down bytes-read=0 bytes-written=16 ",
 			expectPayload: "Internal Server Error",
 		},
 	}
@@ -98,6 +102,7 @@ func (h *InMemoryHandler) Enabled(ctx context.Context, level slog.Level) bool {
 var skipAttr = []string{
 	"req-dur",
 	"ip",
+	"ttfb",
 }
 
 func (h *InMemoryHandler) Handle(_ context.Context, r slog.Record) error {
@@ -135,3 +140,82 @@ func TestMemoryHandler(t *testing.T) {
 		t.Errorf("expected log to contain: %q, got: %q", expected, buf.String())
 	}
 }
+
+func TestSlogMiddleware_Principal(t *testing.T) {
+	buf, logger := newMemSlog()
+	authn := auth.Middleware(auth.Basic{Verifier: auth.StaticUsers{"bob": "secret"}})
+
+	handler := middleware.Logging(logger)(authn(testHandler(200, "ok")))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("bob", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	expect := "INFO method=GET url=/metrics response-code=200 req-id= principal=bob bytes-read=0 bytes-written=2 "
+	if diff := cmp.Diff(buf.String(), expect); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+// hijackEcho takes over the connection and echoes 4 bytes back
+func hijackEcho(w http.ResponseWriter, r *http.Request) {
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+	_ = brw.Flush()
+	buf := make([]byte, 4)
+	_, _ = io.ReadFull(brw, buf)
+	_, _ = conn.Write(buf)
+}
+
+// serveHijacked sends an upgrade request through h and waits until h returned
+func serveHijacked(t *testing.T, h http.Handler) {
+	t.Helper()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nping"))
+	_, _ = io.ReadAll(conn)
+	_ = conn.Close()
+	<-done
+}
+
+func TestSlogMiddleware_Hijacked(t *testing.T) {
+	buf, logger := newMemSlog()
+	serveHijacked(t, middleware.New(middleware.Cfg{Logger: logger, JsonErrors: true}).Middleware(http.HandlerFunc(hijackEcho)))
+
+	expect := "INFO method=GET url=/ws response-code=101 req-id= hijacked=true bytes-read=4 bytes-written=40 "
+	if diff := cmp.Diff(buf.String(), expect); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestSlogMiddleware_Bytes(t *testing.T) {
+	buf, logger := newMemSlog()
+	handler := middleware.Logging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.Copy(w, io.LimitReader(strings.NewReader("hello world"), 100))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/upload", strings.NewReader("payload")))
+
+	expect := "INFO method=POST url=/upload response-code=200 req-id= bytes-read=7 bytes-written=11 "
+	if diff := cmp.Diff(buf.String(), expect); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if rec.Body.String() != "hello world" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+}
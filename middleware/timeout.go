@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Timeout returns a middleware that sets a deadline on the request context. If the handler
+// returns after the deadline without having written a response, the client gets a 503 written
+// with http.Error, so the error middleware renders it. Handlers need to respect the context.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			respWriter := NewWriter(w, false, false)
+
+			next.ServeHTTP(respWriter, r.WithContext(ctx))
+
+			if !respWriter.headerWritten && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				http.Error(w, "request timeout", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
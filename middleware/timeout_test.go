@@ -0,0 +1,50 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+func TestTimeout(t *testing.T) {
+	tcs := []struct {
+		name       string
+		handler    http.HandlerFunc
+		expectCode int
+	}{
+		{
+			name: "in time",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			},
+			expectCode: http.StatusOK,
+		},
+		{
+			name: "timed out",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			expectCode: http.StatusServiceUnavailable,
+		},
+		{
+			name: "response already started",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				<-r.Context().Done()
+			},
+			expectCode: http.StatusAccepted,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			middleware.Timeout(10*time.Millisecond)(tc.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tc.expectCode {
+				t.Errorf("expected %d, got %d", tc.expectCode, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Package router adds route groups with middleware and route naming on top of http.ServeMux.
+package router
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-bumbu/http/middleware"
+)
+
+// Middleware is the standard func(next http.Handler) http.Handler middleware
+type Middleware = func(http.Handler) http.Handler
+
+// Router registers routes on an http.ServeMux, wrapped in the middleware of the router and its
+// parent groups. Patterns use the ServeMux syntax, e.g. "GET /users/{id}".
+type Router struct {
+	mux    *http.ServeMux
+	prefix string
+	mws    []Middleware
+	routes *routeList
+}
+
+type routeList struct {
+	mu     sync.Mutex
+	routes []*Route
+}
+
+// Route is a registered route
+type Route struct {
+	mu      sync.RWMutex
+	method  string
+	pattern string
+	name    string
+}
+
+// RouteInfo describes a registered route, see Routes
+type RouteInfo struct {
+	Method string
+	// Pattern is the full ServeMux pattern including the group prefixes
+	Pattern string
+	Name    string
+}
+
+func New() *Router {
+	return &Router{mux: http.NewServeMux(), routes: &routeList{}}
+}
+
+// Use adds middleware to the routes registered afterward, the first one is the outermost
+func (rt *Router) Use(mws ...Middleware) {
+	rt.mws = append(rt.mws, mws...)
+}
+
+// Group returns a router registering its routes under prefix, e.g. "/api/v1", wrapped in the
+// middleware of rt followed by mws
+func (rt *Router) Group(prefix string, mws ...Middleware) *Router {
+	return &Router{
+		mux:    rt.mux,
+		prefix: rt.prefix + strings.TrimSuffix(prefix, "/"),
+		mws:    append(slices.Clone(rt.mws), mws...),
+		routes: rt.routes,
+	}
+}
+
+// Handle registers h for pattern, e.g. "GET /users/{id}" or "/static/", prefixed with the group
+// prefix. The route is named after the full pattern, see Route.Name. Like ServeMux.Handle it
+// panics on invalid or conflicting patterns.
+func (rt *Router) Handle(pattern string, h http.Handler) *Route {
+	method, host, path := splitPattern(pattern)
+	full := host + rt.prefix + path
+	if method != "" {
+		full = method + " " + full
+	}
+	route := &Route{method: method, pattern: full, name: full}
+
+	for i := len(rt.mws) - 1; i >= 0; i-- {
+		h = rt.mws[i](h)
+	}
+	h = route.named(h)
+	rt.mux.Handle(full, h)
+
+	rt.routes.mu.Lock()
+	rt.routes.routes = append(rt.routes.routes, route)
+	rt.routes.mu.Unlock()
+	return route
+}
+
+// HandleFunc registers fn for pattern, see Handle
+func (rt *Router) HandleFunc(pattern string, fn func(http.ResponseWriter, *http.Request)) *Route {
+	return rt.Handle(pattern, http.HandlerFunc(fn))
+}
+
+// Routes returns the registered routes in the order they were registered
+func (rt *Router) Routes() []RouteInfo {
+	rt.routes.mu.Lock()
+	defer rt.routes.mu.Unlock()
+	infos := make([]RouteInfo, len(rt.routes.routes))
+	for i, r := range rt.routes.routes {
+		r.mu.RLock()
+		infos[i] = RouteInfo{Method: r.method, Pattern: r.pattern, Name: r.name}
+		r.mu.RUnlock()
+	}
+	return infos
+}
+
+// ServeHTTP dispatches the request to the matching route. Requests that match no route are named
+// middleware.RouteUnmatched, so scanners probing random paths don't create new metric labels.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r.WithContext(middleware.WithRoute(r.Context(), middleware.RouteUnmatched)))
+}
+
+// Name sets the route name used as route attribute in logs and as addr label in metrics,
+// see middleware.WithRoute
+func (r *Route) Name(name string) *Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.name = name
+	return r
+}
+
+// named sets the route name on the request before the route middleware run
+func (r *Route) named(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.RLock()
+		name := r.name
+		r.mu.RUnlock()
+		next.ServeHTTP(w, req.WithContext(middleware.WithRoute(req.Context(), name)))
+	})
+}
+
+// splitPattern splits a ServeMux pattern "[METHOD ][HOST]/[PATH]"
+func splitPattern(pattern string) (method, host, path string) {
+	pattern = strings.TrimSpace(pattern)
+	if m, rest, ok := strings.Cut(pattern, " "); ok {
+		method, pattern = m, strings.TrimLeft(rest, " \t")
+	}
+	if i := strings.Index(pattern, "/"); i > 0 {
+		return method, pattern[:i], pattern[i:]
+	}
+	return method, "", pattern
+}
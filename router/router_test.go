@@ -0,0 +1,131 @@
+package router_test
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/go-bumbu/http/middleware"
+	"github.com/go-bumbu/http/router"
+)
+
+// trace appends name to the X-Trace response header, showing the middleware order
+func trace(name string) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func echoRoute(w http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprintf(w, "%s id=%s", middleware.RouteFromContext(r.Context()), r.PathValue("id"))
+}
+
+func newTestRouter() *router.Router {
+	rt := router.New()
+	rt.Use(trace("root"))
+	rt.HandleFunc("GET /healthz", echoRoute)
+
+	api := rt.Group("/api", trace("api"))
+	api.HandleFunc("GET /users/{id}", echoRoute).Name("user")
+	api.HandleFunc("POST /users", echoRoute)
+
+	admin := api.Group("/admin/")
+	admin.Use(trace("admin"))
+	admin.HandleFunc("/", echoRoute)
+	return rt
+}
+
+func TestRouter_Serve(t *testing.T) {
+	rt := newTestRouter()
+	tcs := []struct {
+		name        string
+		method      string
+		target      string
+		expectCode  int
+		expectBody  string
+		expectTrace []string
+	}{
+		{name: "root route", method: "GET", target: "/healthz", expectCode: 200,
+			expectBody: "GET /healthz id=", expectTrace: []string{"root"}},
+		{name: "named group route", method: "GET", target: "/api/users/7", expectCode: 200,
+			expectBody: "user id=7", expectTrace: []string{"root", "api"}},
+		{name: "nested group", method: "GET", target: "/api/admin/stats", expectCode: 200,
+			expectBody: "/api/admin/ id=", expectTrace: []string{"root", "api", "admin"}},
+		{name: "method not allowed", method: "DELETE", target: "/api/users", expectCode: http.StatusMethodNotAllowed},
+		{name: "not found", method: "GET", target: "/other", expectCode: http.StatusNotFound},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+			if rec.Code != tc.expectCode {
+				t.Fatalf("expected %d, got %d", tc.expectCode, rec.Code)
+			}
+			if tc.expectCode != 200 {
+				return
+			}
+			if rec.Body.String() != tc.expectBody {
+				t.Errorf("expected body %q, got %q", tc.expectBody, rec.Body.String())
+			}
+			if diff := cmp.Diff(rec.Header().Values("X-Trace"), tc.expectTrace); diff != "" {
+				t.Errorf("unexpected middleware order (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRouter_Routes(t *testing.T) {
+	want := []router.RouteInfo{
+		{Method: "GET", Pattern: "GET /healthz", Name: "GET /healthz"},
+		{Method: "GET", Pattern: "GET /api/users/{id}", Name: "user"},
+		{Method: "POST", Pattern: "POST /api/users", Name: "POST /api/users"},
+		{Method: "", Pattern: "/api/admin/", Name: "/api/admin/"},
+	}
+	if diff := cmp.Diff(newTestRouter().Routes(), want); diff != "" {
+		t.Errorf("unexpected routes (-got +want)\n%s", diff)
+	}
+}
+
+func TestRouter_RouteLabels(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	reg := prometheus.NewRegistry()
+	hist, err := middleware.NewPromHistogram("", nil, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := middleware.New(middleware.Cfg{Logger: logger, PromHisto: hist})
+
+	rt := router.New()
+	rt.HandleFunc("GET /users/{id}", echoRoute)
+	h := m.Middleware(rt)
+	for _, id := range []string{"1", "2"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/"+id, nil))
+	}
+	for _, path := range []string{"/.env", "/wp-login.php"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if !strings.Contains(logs.String(), `route="GET /users/{id}"`) {
+		t.Errorf("expected route in log lines, got %q", logs.String())
+	}
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `requests_http_duration_seconds_count{addr="GET /users/{id}",isError="false",method="GET",status="200",type="HTTP/1.1"} 2`) {
+		t.Errorf("expected requests counted under the route, got:\n%s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `requests_http_duration_seconds_count{addr="unmatched",isError="true",method="GET",status="404",type="HTTP/1.1"} 2`) {
+		t.Errorf("expected unmatched requests counted under a single label, got:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hijackTracker keeps the connections taken over by handlers, e.g. WebSockets, which
+// http.Server.Shutdown neither waits for nor closes.
+type hijackTracker struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+func newHijackTracker() *hijackTracker {
+	return &hijackTracker{conns: map[*trackedConn]struct{}{}}
+}
+
+func (t *hijackTracker) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&hijackWriter{ResponseWriter: w, tracker: t}, r)
+	})
+}
+
+func (t *hijackTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// wait blocks until all hijacked connections are closed or ctx is done, then closes the
+// remaining ones and returns how many were closed.
+func (t *hijackTracker) wait(ctx context.Context) int {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for t.count() > 0 {
+		select {
+		case <-ctx.Done():
+			t.mu.Lock()
+			conns := make([]*trackedConn, 0, len(t.conns))
+			for c := range t.conns {
+				conns = append(conns, c)
+			}
+			t.mu.Unlock()
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return len(conns)
+		case <-ticker.C:
+		}
+	}
+	return 0
+}
+
+// hijackWriter registers hijacked connections with the tracker
+type hijackWriter struct {
+	http.ResponseWriter
+	tracker *hijackTracker
+}
+
+func (h *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(h.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	tc := &trackedConn{Conn: conn, tracker: h.tracker}
+	h.tracker.mu.Lock()
+	h.tracker.conns[tc] = struct{}{}
+	h.tracker.mu.Unlock()
+	return tc, brw, nil
+}
+
+// Flush keeps the type assertion to http.Flusher working for streaming handlers
+func (h *hijackWriter) Flush() {
+	_ = http.NewResponseController(h.ResponseWriter).Flush()
+}
+
+// ReadFrom keeps the io.ReaderFrom fast path of the server, e.g. sendfile for http.ServeFile,
+// which middleware.StatWriter forwards to
+func (h *hijackWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := h.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(h.ResponseWriter, src)
+}
+
+// Unwrap allows http.ResponseController to reach the other optional interfaces
+func (h *hijackWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *hijackTracker
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.tracker.mu.Lock()
+		delete(c.tracker.conns, c)
+		c.tracker.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
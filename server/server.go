@@ -0,0 +1,250 @@
+// Package server runs http servers with graceful shutdown on SIGINT and SIGTERM.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Readiness is set to false when the shutdown starts, so load balancers stop sending traffic
+// during the drain delay, e.g. the health handler of handlers/health.
+type Readiness interface {
+	SetReady(ready bool)
+}
+
+// Listener is a single http server, e.g. the application or the admin/metrics port
+type Listener struct {
+	// Name identifies the listener in logs and errors, e.g. "app" or "admin"
+	Name    string
+	Addr    string
+	Handler http.Handler
+	// Listener is used instead of listening on Addr when set
+	Listener net.Listener
+	// TLSConfig serves HTTPS when set, it needs to contain the certificates
+	TLSConfig *tls.Config
+}
+
+// Cfg configures the Server
+type Cfg struct {
+	Listeners []Listener
+	// Readiness is set to true once all listeners are open and to false when the shutdown starts
+	Readiness Readiness
+	// DrainDelay is the time between flipping readiness and closing the listeners, it gives load
+	// balancers time to notice; default 0
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds the wait for in flight requests and hijacked connections, default 30s
+	ShutdownTimeout time.Duration
+	// ReadHeaderTimeout default 10s
+	ReadHeaderTimeout time.Duration
+	// IdleTimeout of keep-alive connections, default 120s
+	IdleTimeout time.Duration
+	// Signals start the shutdown, default SIGINT and SIGTERM
+	Signals []os.Signal
+	Logger  *slog.Logger
+}
+
+// Server runs one or more http servers until a signal is received or the context is canceled
+type Server struct {
+	cfg      Cfg
+	hijacked *hijackTracker
+}
+
+func New(cfg Cfg) (*Server, error) {
+	if len(cfg.Listeners) == 0 {
+		return nil, errors.New("at least one listener is required")
+	}
+	cfg.Listeners = slices.Clone(cfg.Listeners)
+	names := map[string]bool{}
+	for i, l := range cfg.Listeners {
+		if l.Handler == nil {
+			return nil, fmt.Errorf("listener %q: handler is required", l.Name)
+		}
+		if l.Addr == "" && l.Listener == nil {
+			return nil, fmt.Errorf("listener %q: addr or listener is required", l.Name)
+		}
+		if l.Name == "" {
+			cfg.Listeners[i].Name = l.Addr
+		}
+		if names[cfg.Listeners[i].Name] {
+			return nil, fmt.Errorf("duplicate listener name %q", cfg.Listeners[i].Name)
+		}
+		names[cfg.Listeners[i].Name] = true
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+	if cfg.ReadHeaderTimeout == 0 {
+		cfg.ReadHeaderTimeout = 10 * time.Second
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = 120 * time.Second
+	}
+	if len(cfg.Signals) == 0 {
+		cfg.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(discardHandler{})
+	}
+	return &Server{cfg: cfg, hijacked: newHijackTracker()}, nil
+}
+
+type running struct {
+	name string
+	srv  *http.Server
+}
+
+// Run serves all listeners and blocks until ctx is canceled, a signal is received or a listener
+// fails, then shuts down gracefully. The returned error joins the errors of all listeners and
+// of the shutdown, it is nil on a clean shutdown.
+func (s *Server) Run(ctx context.Context) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, s.cfg.Signals...)
+	defer signal.Stop(sig)
+
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	var errMu sync.Mutex
+	failed := make(chan struct{}, len(listeners))
+	var wg sync.WaitGroup
+	servers := make([]running, len(listeners))
+	for i, l := range s.cfg.Listeners {
+		srv := &http.Server{
+			Handler:           s.hijacked.wrap(l.Handler),
+			TLSConfig:         l.TLSConfig,
+			ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+			IdleTimeout:       s.cfg.IdleTimeout,
+			ErrorLog:          slog.NewLogLogger(s.cfg.Logger.Handler(), slog.LevelError),
+		}
+		servers[i] = running{name: l.Name, srv: srv}
+		wg.Add(1)
+		go func(name string, ln net.Listener) {
+			defer wg.Done()
+			s.cfg.Logger.Info("server listening", slog.String("name", name), slog.String("addr", ln.Addr().String()))
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.cfg.Logger.Error("server failed", slog.String("name", name), slog.Any("err", err))
+				errMu.Lock()
+				errs = append(errs, fmt.Errorf("listener %q: %w", name, err))
+				errMu.Unlock()
+				failed <- struct{}{}
+			}
+		}(l.Name, listeners[i])
+	}
+	s.setReady(true)
+
+	select {
+	case received := <-sig:
+		s.cfg.Logger.Info("shutdown started", slog.String("cause", "signal "+received.String()))
+	case <-ctx.Done():
+		s.cfg.Logger.Info("shutdown started", slog.String("cause", "context done"))
+	case <-failed:
+		s.cfg.Logger.Info("shutdown started", slog.String("cause", "listener failed"))
+	}
+	signal.Stop(sig)
+
+	s.setReady(false)
+	if s.cfg.DrainDelay > 0 {
+		s.cfg.Logger.Info("draining", slog.Duration("delay", s.cfg.DrainDelay))
+		time.Sleep(s.cfg.DrainDelay)
+	}
+
+	shutdownErrs := s.shutdown(servers)
+	wg.Wait()
+	errs = append(errs, shutdownErrs...)
+	err = errors.Join(errs...)
+	if err != nil {
+		s.cfg.Logger.Error("server stopped", slog.Any("err", err))
+	} else {
+		s.cfg.Logger.Info("server stopped")
+	}
+	return err
+}
+
+// listen opens all listeners, if one fails the ones already open are closed
+func (s *Server) listen() ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(s.cfg.Listeners))
+	for _, l := range s.cfg.Listeners {
+		ln := l.Listener
+		if ln == nil {
+			var err error
+			ln, err = net.Listen("tcp", l.Addr)
+			if err != nil {
+				for _, open := range listeners {
+					_ = open.Close()
+				}
+				return nil, fmt.Errorf("listener %q: %w", l.Name, err)
+			}
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// shutdown stops all servers in parallel and waits for the hijacked connections, the ones still
+// open after the timeout are closed.
+func (s *Server) shutdown(servers []running) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, r := range servers {
+		wg.Add(1)
+		go func(r running) {
+			defer wg.Done()
+			if err := r.srv.Shutdown(ctx); err != nil {
+				_ = r.srv.Close()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shutting down listener %q: %w", r.name, err))
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if n := s.hijacked.wait(ctx); n > 0 {
+		s.cfg.Logger.Warn("closing hijacked connections", slog.Int("count", n))
+		errs = append(errs, fmt.Errorf("%d hijacked connections still open after %s", n, s.cfg.ShutdownTimeout))
+	}
+	return errs
+}
+
+func (s *Server) setReady(ready bool) {
+	if s.cfg.Readiness != nil {
+		s.cfg.Readiness.SetReady(ready)
+	}
+}
+
+// HijackedConns returns the number of open hijacked connections, e.g. WebSockets
+func (s *Server) HijackedConns() int {
+	return s.hijacked.count()
+}
+
+// discardHandler drops all records, slog.DiscardHandler is only available from go 1.24
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
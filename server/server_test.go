@@ -0,0 +1,272 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-bumbu/http/server"
+)
+
+// readiness records the readiness changes
+type readiness struct {
+	mu      sync.Mutex
+	changes []bool
+	ready   chan struct{}
+}
+
+func newReadiness() *readiness {
+	return &readiness{ready: make(chan struct{})}
+}
+
+func (r *readiness) SetReady(ready bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, ready)
+	if ready {
+		close(r.ready)
+	}
+}
+
+func (r *readiness) Changes() []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool(nil), r.changes...)
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+func text(s string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, s)
+	})
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestNew_Validation(t *testing.T) {
+	tcs := []struct {
+		name string
+		cfg  server.Cfg
+	}{
+		{name: "no listeners", cfg: server.Cfg{}},
+		{name: "no handler", cfg: server.Cfg{Listeners: []server.Listener{{Name: "app", Addr: ":0"}}}},
+		{name: "no addr", cfg: server.Cfg{Listeners: []server.Listener{{Name: "app", Handler: text("")}}}},
+		{name: "duplicate name", cfg: server.Cfg{Listeners: []server.Listener{
+			{Name: "app", Addr: ":0", Handler: text("")},
+			{Name: "app", Addr: ":1", Handler: text("")},
+		}}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := server.New(tc.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestServer_RunMultipleListeners(t *testing.T) {
+	var logs bytes.Buffer
+	app, admin := listen(t), listen(t)
+	ready := newReadiness()
+	srv, err := server.New(server.Cfg{
+		Listeners: []server.Listener{
+			{Name: "app", Listener: app, Handler: text("app")},
+			{Name: "admin", Listener: admin, Handler: text("admin")},
+		},
+		Readiness:  ready,
+		DrainDelay: 10 * time.Millisecond,
+		Logger:     slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Run(ctx) }()
+	<-ready.ready
+
+	if got := get(t, "http://"+app.Addr().String()); got != "app" {
+		t.Errorf("unexpected app response %q", got)
+	}
+	if got := get(t, "http://"+admin.Addr().String()); got != "admin" {
+		t.Errorf("unexpected admin response %q", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes := ready.Changes(); len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("expected readiness true then false, got %v", changes)
+	}
+	for _, msg := range []string{"server listening", "name=admin", "shutdown started", "draining", "server stopped"} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Errorf("expected %q in logs:\n%s", msg, logs.String())
+		}
+	}
+}
+
+func TestServer_ReaderFrom(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("file content")
+	_ = f.Close()
+
+	ln := listen(t)
+	ready := newReadiness()
+	srv, err := server.New(server.Cfg{
+		Listeners: []server.Listener{{Name: "app", Listener: ln, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// http.ServeFile and io.Copy use sendfile only if the writer is an io.ReaderFrom
+			if _, ok := w.(io.ReaderFrom); !ok {
+				http.Error(w, "no io.ReaderFrom", http.StatusInternalServerError)
+				return
+			}
+			http.ServeFile(w, r, f.Name())
+		})}},
+		Readiness: ready,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Run(ctx) }()
+	<-ready.ready
+
+	if got := get(t, "http://"+ln.Addr().String()); got != "file content" {
+		t.Errorf("unexpected response %q", got)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServer_Signal(t *testing.T) {
+	ready := newReadiness()
+	srv, err := server.New(server.Cfg{
+		Listeners: []server.Listener{{Name: "app", Listener: listen(t), Handler: text("app")}},
+		Readiness: ready,
+		Signals:   []os.Signal{os.Interrupt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- srv.Run(context.Background()) }()
+	<-ready.ready
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skipf("sending signals not supported: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not stop on signal")
+	}
+}
+
+func TestServer_ListenError(t *testing.T) {
+	taken := listen(t)
+	defer func() { _ = taken.Close() }()
+	srv, err := server.New(server.Cfg{
+		Listeners: []server.Listener{{Name: "app", Addr: taken.Addr().String(), Handler: text("app")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `listener "app"`) {
+		t.Errorf("expected listen error, got %v", err)
+	}
+}
+
+func TestServer_HijackedConnections(t *testing.T) {
+	ln := listen(t)
+	ready := newReadiness()
+	hijacked := make(chan struct{})
+	srv, err := server.New(server.Cfg{
+		Listeners: []server.Listener{{Name: "ws", Listener: ln, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, brw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			_ = brw.Flush()
+			close(hijacked)
+			// keep the connection until it is closed by the server
+			_, _ = io.Copy(io.Discard, conn)
+		})}},
+		Readiness:       ready,
+		ShutdownTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- srv.Run(ctx) }()
+	<-ready.ready
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	status, _ := bufio.NewReader(conn).ReadString('\n')
+	if !strings.HasPrefix(status, "HTTP/1.1 101") {
+		t.Fatalf("unexpected status %q", status)
+	}
+	<-hijacked
+	if srv.HijackedConns() != 1 {
+		t.Errorf("expected 1 hijacked connection, got %d", srv.HijackedConns())
+	}
+
+	cancel()
+	err = <-done
+	if err == nil || !strings.Contains(err.Error(), "1 hijacked connections still open") {
+		t.Errorf("expected hijacked connection error, got %v", err)
+	}
+	if srv.HijackedConns() != 0 {
+		t.Errorf("expected hijacked connection to be closed, got %d", srv.HijackedConns())
+	}
+}